@@ -0,0 +1,83 @@
+// Package auth gives each device a long-term identity and a pairing flow so
+// peers on the LAN can establish mutual trust before they are allowed to
+// read files or spoof each other's beacons.
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// configDirName is the subdirectory of the OS config dir we persist
+// identity and trust data under.
+const configDirName = "airshare"
+
+const identityFileName = "identity.key"
+
+// Identity is this device's long-term Ed25519 keypair.
+type Identity struct {
+	Public  ed25519.PublicKey
+	Private ed25519.PrivateKey
+}
+
+// LoadOrCreateIdentity loads the persisted identity from the config dir,
+// generating and saving a new one on first run.
+func LoadOrCreateIdentity() (*Identity, error) {
+	path, err := identityPath()
+	if err != nil {
+		return nil, err
+	}
+
+	if data, err := os.ReadFile(path); err == nil {
+		if len(data) != ed25519.PrivateKeySize {
+			return nil, fmt.Errorf("identity file %s is corrupt", path)
+		}
+		priv := ed25519.PrivateKey(data)
+		return &Identity{Public: priv.Public().(ed25519.PublicKey), Private: priv}, nil
+	}
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate identity: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create config dir: %v", err)
+	}
+	if err := os.WriteFile(path, priv, 0600); err != nil {
+		return nil, fmt.Errorf("failed to persist identity: %v", err)
+	}
+
+	return &Identity{Public: pub, Private: priv}, nil
+}
+
+// Fingerprint returns a short, stable identifier for this identity's public
+// key, suitable for advertising in discovery.State.
+func (id *Identity) Fingerprint() string {
+	return Fingerprint(id.Public)
+}
+
+// PublicKeyHex returns this identity's public key, hex-encoded for
+// advertising in discovery.State.
+func (id *Identity) PublicKeyHex() string {
+	return hex.EncodeToString(id.Public)
+}
+
+// Fingerprint derives a short identifier from a raw Ed25519 public key.
+func Fingerprint(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+func identityPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve config dir: %v", err)
+	}
+	return filepath.Join(dir, configDirName, identityFileName), nil
+}
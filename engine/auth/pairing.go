@@ -0,0 +1,238 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/flynn/noise"
+)
+
+// sasDigits is the length of the short authentication string the user
+// compares by eye on both devices, as in Signal/WhatsApp-style pairing.
+const sasDigits = 6
+
+// noiseConfig is the Noise-IK cipher suite used for pairing. IK lets the
+// initiator authenticate the responder's long-term key in the first
+// message, which is what lets us derive a SAS after a single round trip.
+var noiseConfig = noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashSHA256)
+
+// PendingPeer is a peer we have completed a Noise handshake with but the
+// user has not yet confirmed the SAS for.
+type PendingPeer struct {
+	Fingerprint string
+	PublicKey   ed25519.PublicKey
+	SessionKey  []byte
+	SAS         string
+}
+
+// Pairing runs both sides of the Noise-IK pairing handshake: HandleInitMessage
+// answers the /pair HTTP endpoint as a responder, and Initiate drives the
+// same handshake as an initiator against a peer's /pair endpoint.
+type Pairing struct {
+	identity *Identity
+	dhKey    noise.DHKey
+
+	mu      sync.Mutex
+	pending map[string]*PendingPeer // keyed by fingerprint
+}
+
+// NewPairing prepares a Pairing for the given device identity. The Noise
+// static keypair is the X25519 keypair that corresponds to the same private
+// scalar as the Ed25519 identity (see x25519.go), not an independently
+// generated key: that's what lets Initiate compute a peer's Noise static
+// public key from nothing but the Ed25519 public key it broadcasts in its
+// beacon, instead of requiring a separate out-of-band key exchange first.
+func NewPairing(id *Identity) (*Pairing, error) {
+	priv := x25519PrivateFromSeed(id.Private.Seed())
+	pub, err := x25519PublicFromEd25519(id.Public)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive noise keypair: %v", err)
+	}
+
+	return &Pairing{
+		identity: id,
+		dhKey:    noise.DHKey{Private: priv, Public: pub},
+		pending:  make(map[string]*PendingPeer),
+	}, nil
+}
+
+// HandleInitMessage runs the responder side of a Noise-IK handshake against
+// the initiator's first message and returns the reply message to send back,
+// along with the SAS the user should compare on screen. hs.PeerStatic() is
+// the initiator's X25519 static key, not their Ed25519 identity, so the
+// initiator also carries its raw Ed25519 public key as the first message's
+// payload (see Initiate); HandleInitMessage cross-checks that the Ed25519
+// key it claims actually converts to the X25519 key the handshake just
+// authenticated, so a peer can't pair under someone else's fingerprint.
+func (p *Pairing) HandleInitMessage(msg1 []byte) (reply []byte, peer *PendingPeer, err error) {
+	hs, err := noise.NewHandshakeState(noise.Config{
+		CipherSuite:   noiseConfig,
+		Pattern:       noise.HandshakeIK,
+		Initiator:     false,
+		StaticKeypair: p.dhKey,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to start handshake: %v", err)
+	}
+
+	payload, _, _, err := hs.ReadMessage(nil, msg1)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid pairing message: %v", err)
+	}
+	if len(payload) != ed25519.PublicKeySize {
+		return nil, nil, fmt.Errorf("pairing message missing identity payload")
+	}
+	remoteEd := ed25519.PublicKey(payload)
+
+	expectedStatic, err := x25519PublicFromEd25519(remoteEd)
+	if err != nil || !bytes.Equal(expectedStatic, hs.PeerStatic()) {
+		return nil, nil, fmt.Errorf("pairing identity does not match handshake key")
+	}
+
+	reply, cs1, cs2, err := hs.WriteMessage(nil, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to build pairing reply: %v", err)
+	}
+
+	fingerprint := Fingerprint(remoteEd)
+	sessionKey := sessionKeyFrom(cs1, cs2)
+	sas := deriveSAS(hs.ChannelBinding())
+
+	pending := &PendingPeer{
+		Fingerprint: fingerprint,
+		PublicKey:   remoteEd,
+		SessionKey:  sessionKey,
+		SAS:         sas,
+	}
+
+	p.mu.Lock()
+	p.pending[fingerprint] = pending
+	p.mu.Unlock()
+
+	return reply, pending, nil
+}
+
+// Initiate runs the initiator side of a Noise-IK handshake against a peer
+// we learned about via discovery: remotePublicKeyHex comes straight off
+// that peer's beacon. Noise IK authenticates the responder's *static DH*
+// key, which is the peer's X25519 key derived from that Ed25519 public key
+// (see x25519.go), not the Ed25519 key itself - converting it is what lets
+// IK complete in a single round trip instead of requiring a separate
+// out-of-band key exchange first. Our own Ed25519 public key rides along as
+// the first message's payload so the responder can recover our fingerprint.
+// It posts the first handshake message to pairURL (e.g. http://ip:port/pair)
+// and returns the SAS the user should compare against the responder's
+// display. client is the http.Client to post the handshake over; pass nil
+// to use http.DefaultClient, or a relay-dialer-backed client for a peer
+// reached via PAIR_REMOTE instead of LAN discovery.
+func (p *Pairing) Initiate(pairURL, remotePublicKeyHex string, client *http.Client) (fingerprint, sas string, err error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	remotePub, err := hex.DecodeString(remotePublicKeyHex)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid peer public key: %v", err)
+	}
+	remoteStatic, err := x25519PublicFromEd25519(ed25519.PublicKey(remotePub))
+	if err != nil {
+		return "", "", fmt.Errorf("invalid peer public key: %v", err)
+	}
+
+	hs, err := noise.NewHandshakeState(noise.Config{
+		CipherSuite:   noiseConfig,
+		Pattern:       noise.HandshakeIK,
+		Initiator:     true,
+		StaticKeypair: p.dhKey,
+		PeerStatic:    remoteStatic,
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("failed to start handshake: %v", err)
+	}
+
+	msg1, _, _, err := hs.WriteMessage(nil, p.identity.Public)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to build pairing message: %v", err)
+	}
+
+	resp, err := client.Post(pairURL, "application/octet-stream", bytes.NewReader(msg1))
+	if err != nil {
+		return "", "", fmt.Errorf("pairing request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	msg2, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read pairing reply: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("pairing rejected: %s", resp.Status)
+	}
+
+	if _, cs1, cs2, err := hs.ReadMessage(nil, msg2); err != nil {
+		return "", "", fmt.Errorf("invalid pairing reply: %v", err)
+	} else {
+		fingerprint = Fingerprint(ed25519.PublicKey(remotePub))
+		sessionKey := sessionKeyFrom(cs1, cs2)
+		sas = deriveSAS(hs.ChannelBinding())
+
+		p.mu.Lock()
+		p.pending[fingerprint] = &PendingPeer{
+			Fingerprint: fingerprint,
+			PublicKey:   ed25519.PublicKey(remotePub),
+			SessionKey:  sessionKey,
+			SAS:         sas,
+		}
+		p.mu.Unlock()
+	}
+
+	return fingerprint, sas, nil
+}
+
+// Confirm moves a pending peer into the trust store once the user has
+// visually compared the SAS on both devices.
+func (p *Pairing) Confirm(fingerprint, name string, trust *TrustStore) error {
+	p.mu.Lock()
+	pending, ok := p.pending[fingerprint]
+	delete(p.pending, fingerprint)
+	p.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("no pending pairing for %s", fingerprint)
+	}
+
+	return trust.Trust(pending.Fingerprint, pending.PublicKey, pending.SessionKey, name)
+}
+
+// sessionKeyFrom combines both directional cipher states from a completed
+// Noise handshake into a single symmetric key used to sign bearer tokens.
+func sessionKeyFrom(cs1, cs2 *noise.CipherState) []byte {
+	k1 := cs1.UnsafeKey()
+	k2 := cs2.UnsafeKey()
+	combined := sha256.New()
+	combined.Write(k1[:])
+	combined.Write(k2[:])
+	return combined.Sum(nil)
+}
+
+// deriveSAS turns a handshake's channel-binding hash into a short decimal
+// string a human can read aloud to compare on both screens.
+func deriveSAS(binding []byte) string {
+	n := 0
+	for i := 0; i < 4 && i < len(binding); i++ {
+		n = n<<8 | int(binding[i])
+	}
+	if n < 0 {
+		n = -n
+	}
+	mod := 1
+	for i := 0; i < sasDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", sasDigits, n%mod)
+}
@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const trustFileName = "trust.json"
+
+// tokenTTL bounds how long an issued bearer token is valid for. Since
+// traffic is plain HTTP, anyone who passively observes one authenticated
+// request can replay it - binding the token to a short-lived expiry keeps
+// that replay window bounded instead of letting a captured token work
+// forever.
+const tokenTTL = 5 * time.Minute
+
+// TrustedPeer is a device we have completed a pairing handshake with.
+type TrustedPeer struct {
+	Fingerprint string `json:"fingerprint"`
+	PublicKey   string `json:"publicKey"` // hex-encoded Ed25519 public key
+	Name        string `json:"name,omitempty"`
+	SessionKey  string `json:"sessionKey"` // hex-encoded key derived from the Noise handshake
+}
+
+// TrustStore persists the set of peers we have paired with and issues/
+// verifies the bearer tokens derived from each peer's session key.
+type TrustStore struct {
+	mu    sync.RWMutex
+	path  string
+	peers map[string]TrustedPeer // keyed by fingerprint
+}
+
+// LoadTrustStore loads the trust store from the config dir, starting empty
+// if none exists yet.
+func LoadTrustStore() (*TrustStore, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve config dir: %v", err)
+	}
+	path := filepath.Join(dir, configDirName, trustFileName)
+
+	t := &TrustStore{path: path, peers: make(map[string]TrustedPeer)}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return t, nil
+		}
+		return nil, fmt.Errorf("failed to read trust store: %v", err)
+	}
+	if err := json.Unmarshal(data, &t.peers); err != nil {
+		return nil, fmt.Errorf("failed to parse trust store: %v", err)
+	}
+	return t, nil
+}
+
+// Trust records a peer as trusted, persisting the store to disk.
+func (t *TrustStore) Trust(fingerprint string, pub ed25519.PublicKey, sessionKey []byte, name string) error {
+	t.mu.Lock()
+	t.peers[fingerprint] = TrustedPeer{
+		Fingerprint: fingerprint,
+		PublicKey:   hex.EncodeToString(pub),
+		Name:        name,
+		SessionKey:  hex.EncodeToString(sessionKey),
+	}
+	t.mu.Unlock()
+	return t.save()
+}
+
+// Untrust removes a peer from the trust store.
+func (t *TrustStore) Untrust(fingerprint string) error {
+	t.mu.Lock()
+	delete(t.peers, fingerprint)
+	t.mu.Unlock()
+	return t.save()
+}
+
+// IsTrusted reports whether fingerprint belongs to a paired peer.
+func (t *TrustStore) IsTrusted(fingerprint string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	_, ok := t.peers[fingerprint]
+	return ok
+}
+
+// List returns every trusted peer.
+func (t *TrustStore) List() []TrustedPeer {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	peers := make([]TrustedPeer, 0, len(t.peers))
+	for _, p := range t.peers {
+		peers = append(peers, p)
+	}
+	return peers
+}
+
+// IssueToken returns a bearer token for fingerprint, HMAC-signed with that
+// peer's session key and valid for tokenTTL. The token is opaque to
+// everyone else on the LAN, and its expiry bounds how long a captured
+// token stays replayable.
+func (t *TrustStore) IssueToken(fingerprint string) (string, error) {
+	t.mu.RLock()
+	peer, ok := t.peers[fingerprint]
+	t.mu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("unknown peer: %s", fingerprint)
+	}
+
+	expiry := time.Now().Add(tokenTTL).Unix()
+	sig, err := signToken(fingerprint, expiry, peer.SessionKey)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s.%d.%s", fingerprint, expiry, sig), nil
+}
+
+// VerifyBearer checks an "Authorization: Bearer <token>" value and returns
+// the fingerprint of the authenticated peer. It rejects tokens past their
+// embedded expiry as well as tokens with a bad signature.
+func (t *TrustStore) VerifyBearer(token string) (fingerprint string, ok bool) {
+	parts := strings.SplitN(token, ".", 3)
+	if len(parts) != 3 {
+		return "", false
+	}
+	fingerprint, expiryStr, sig := parts[0], parts[1], parts[2]
+
+	expiry, err := strconv.ParseInt(expiryStr, 10, 64)
+	if err != nil || time.Now().Unix() > expiry {
+		return "", false
+	}
+
+	t.mu.RLock()
+	peer, exists := t.peers[fingerprint]
+	t.mu.RUnlock()
+	if !exists {
+		return "", false
+	}
+
+	expected, err := signToken(fingerprint, expiry, peer.SessionKey)
+	if err != nil || !hmac.Equal([]byte(sig), []byte(expected)) {
+		return "", false
+	}
+	return fingerprint, true
+}
+
+func signToken(fingerprint string, expiry int64, hexKey string) (string, error) {
+	key, err := hex.DecodeString(hexKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid session key: %v", err)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(fingerprint))
+	mac.Write([]byte("."))
+	mac.Write([]byte(strconv.FormatInt(expiry, 10)))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+func (t *TrustStore) save() error {
+	t.mu.RLock()
+	data, err := json.MarshalIndent(t.peers, "", "  ")
+	t.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("failed to marshal trust store: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(t.path), 0700); err != nil {
+		return fmt.Errorf("failed to create config dir: %v", err)
+	}
+	return os.WriteFile(t.path, data, 0600)
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/sha512"
+	"fmt"
+	"math/big"
+)
+
+// p25519 is the field prime 2^255 - 19 that both Ed25519 and X25519 are
+// defined over. The two curves are birationally equivalent over this field,
+// which is what the conversions below rely on.
+var p25519 = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+
+// x25519PrivateFromSeed derives an X25519 private scalar from an Ed25519
+// identity seed. This is the same SHA-512-then-clamp step RFC 8032 uses to
+// expand an Ed25519 seed into its signing scalar, which is why the result
+// is also a valid X25519 scalar - it's the same trick libsodium's
+// crypto_sign_ed25519_sk_to_curve25519 relies on.
+func x25519PrivateFromSeed(seed []byte) []byte {
+	h := sha512.Sum512(seed)
+	scalar := append([]byte(nil), h[:32]...)
+	scalar[0] &= 248
+	scalar[31] &= 127
+	scalar[31] |= 64
+	return scalar
+}
+
+// x25519PublicFromEd25519 converts an Ed25519 public key to the X25519
+// public key for the same private scalar, via the birational map between
+// the twisted Edwards curve and Curve25519: u = (1+y)/(1-y) mod p, where y
+// is the Edwards y-coordinate recovered from the compressed Ed25519 key.
+// Unlike the private-key conversion this needs no secret material, which is
+// what lets Initiate derive a peer's Noise static key from nothing but the
+// Ed25519 public key it already broadcasts in its beacon.
+func x25519PublicFromEd25519(pub ed25519.PublicKey) ([]byte, error) {
+	if len(pub) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid ed25519 public key length: %d", len(pub))
+	}
+
+	// The Ed25519 encoding is y, little-endian, with the top bit of the
+	// last byte borrowed to carry the sign of x; clear it to recover y.
+	yBytes := append([]byte(nil), pub...)
+	yBytes[31] &= 0x7f
+	y := leBytesToInt(yBytes)
+
+	one := big.NewInt(1)
+	numerator := new(big.Int).Mod(new(big.Int).Add(one, y), p25519)
+	denominator := new(big.Int).Mod(new(big.Int).Sub(one, y), p25519)
+	denominator.ModInverse(denominator, p25519)
+
+	u := new(big.Int).Mod(numerator.Mul(numerator, denominator), p25519)
+	return intToLEBytes(u, 32), nil
+}
+
+func leBytesToInt(b []byte) *big.Int {
+	be := make([]byte, len(b))
+	for i, v := range b {
+		be[len(b)-1-i] = v
+	}
+	return new(big.Int).SetBytes(be)
+}
+
+func intToLEBytes(n *big.Int, size int) []byte {
+	be := n.Bytes()
+	out := make([]byte, size)
+	copy(out[size-len(be):], be)
+	for i, j := 0, size-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out
+}
@@ -1,210 +1,284 @@
 package discovery
 
 import (
-	"encoding/json"
 	"fmt"
-	"net"
 	"os"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
+
+	"airshare-engine/events"
 )
 
 const (
-	DiscoveryPort = 9988
-	BroadcastAddr = "255.255.255.255:9988"
-	ServicePort   = 8080
-	BeaconInterval = 1 * time.Second
+	ServicePort = 8080
+	// BeaconInterval only needs to be fast enough for liveness now that grab
+	// state changes push through the event stream instead of riding the
+	// next beacon tick.
+	BeaconInterval = 5 * time.Second
+	// peerTimeout is how long we'll go without hearing from a peer on any
+	// backend before declaring it gone.
+	peerTimeout = 3 * BeaconInterval
 )
 
-// BeaconPacket is the payload broadcasted by each device
-type BeaconPacket struct {
+// State is the information this device advertises over every discovery
+// backend.
+type State struct {
 	DeviceID    string `json:"deviceId"`
 	DeviceName  string `json:"deviceName"`
 	ServicePort int    `json:"servicePort"`
-	// Grab state for P2P transfer
+	Fingerprint string `json:"fingerprint"`
+	PublicKey   string `json:"publicKey"`
 	IsHolding   bool   `json:"isHolding"`
 	HeldFile    string `json:"heldFile,omitempty"`
+	// HeldType, HeldSize and HeldCount turn HeldFile from a bare filename
+	// into a full grab descriptor once SetGrab is pointed at a directory:
+	// HeldType is "file" or "dir", HeldSize is the total bytes shared, and
+	// HeldCount is how many files that covers (1 for a plain file).
+	HeldType  string `json:"heldType,omitempty"`
+	HeldSize  int64  `json:"heldSize,omitempty"`
+	HeldCount int    `json:"heldCount,omitempty"`
 }
 
-// Peer represents a discovered peer
+// Peer represents a discovered peer, regardless of which backend found it.
 type Peer struct {
-	ID        string `json:"id"`
-	IP        string `json:"ip"`
-	Name      string `json:"name"`
-	IsHolding bool   `json:"isHolding"`
-	HeldFile  string `json:"heldFile,omitempty"`
+	ID          string `json:"id"`
+	IP          string `json:"ip"`
+	Name        string `json:"name"`
+	Fingerprint string `json:"fingerprint"`
+	PublicKey   string `json:"publicKey"`
+	IsHolding   bool   `json:"isHolding"`
+	HeldFile    string `json:"heldFile,omitempty"`
+	HeldType    string `json:"heldType,omitempty"`
+	HeldSize    int64  `json:"heldSize,omitempty"`
+	HeldCount   int    `json:"heldCount,omitempty"`
+
+	lastSeen time.Time
 }
 
-// Discovery manages the UDP discovery protocol
+// Backend is a pluggable peer-discovery transport. UDPBroadcast and MDNS
+// both implement it; Discovery runs every configured backend concurrently
+// and merges their peer views, deduplicating by DeviceID.
+type Backend interface {
+	// Start begins advertising whatever onState currently returns and
+	// reports every peer it sees (new or updated) to onPeer.
+	Start(onState func() State, onPeer func(Peer)) error
+	// Stop halts the backend.
+	Stop()
+	// Peers returns this backend's own cached view of the peer set, or nil
+	// for a backend that reports peers only through onPeer (UDPBroadcast
+	// and MDNS both do).
+	Peers() []Peer
+	// Announce pushes an updated local state out immediately, rather than
+	// waiting for the backend's own refresh interval.
+	Announce(state State)
+}
+
+// Discovery manages peer discovery across one or more Backends.
 type Discovery struct {
-	deviceID   string
-	deviceName string
-	peers      map[string]*Peer
-	peersMu    sync.RWMutex
-	stopChan   chan struct{}
+	deviceID    string
+	deviceName  string
+	fingerprint string
+	publicKey   string
+
+	backendsMu sync.RWMutex
+	backends   []Backend
+
+	peers    map[string]*Peer
+	peersMu  sync.RWMutex
+	eventsCh chan events.Event
+
 	// Grab state
-	isHolding  bool
-	heldFile   string
-	grabMu     sync.RWMutex
+	isHolding bool
+	heldFile  string
+	heldIsDir bool
+	heldSize  int64
+	heldCount int
+	grabMu    sync.RWMutex
+
+	reapStop chan struct{}
 }
 
-// New creates a new Discovery instance
-func New() *Discovery {
+// New creates a new Discovery instance that runs the given backends
+// concurrently. fingerprint and publicKey identify this device's
+// long-term identity and are advertised over every backend so peers can
+// tell a genuine device from one spoofing its DeviceID, and can pair with
+// it via Noise-IK without a prior key exchange.
+func New(fingerprint, publicKey string, backends ...Backend) *Discovery {
 	hostname, err := os.Hostname()
 	if err != nil {
 		hostname = "Unknown"
 	}
 
 	return &Discovery{
-		deviceID:   uuid.New().String(),
-		deviceName: hostname,
-		peers:      make(map[string]*Peer),
-		stopChan:   make(chan struct{}),
+		deviceID:    uuid.New().String(),
+		deviceName:  hostname,
+		fingerprint: fingerprint,
+		publicKey:   publicKey,
+		backends:    backends,
+		peers:       make(map[string]*Peer),
+		eventsCh:    make(chan events.Event, 32),
+		reapStop:    make(chan struct{}),
 	}
 }
 
-// Start begins broadcasting and listening for peers
+// Start begins every configured backend.
 func (d *Discovery) Start() error {
-	// Start the beacon broadcaster
-	go d.startBeacon()
+	d.backendsMu.RLock()
+	defer d.backendsMu.RUnlock()
 
-	// Start the listener
-	go d.startListener()
+	for _, b := range d.backends {
+		if err := b.Start(d.state, d.handlePeer); err != nil {
+			return fmt.Errorf("failed to start backend: %v", err)
+		}
+	}
 
+	go d.reapStaleLoop()
 	return nil
 }
 
-// Stop halts the discovery service
+// Stop halts every configured backend.
 func (d *Discovery) Stop() {
-	close(d.stopChan)
-}
+	close(d.reapStop)
 
-// startBeacon broadcasts our presence every second
-func (d *Discovery) startBeacon() {
-	// For Windows compatibility, we use DialUDP with broadcast address
-	broadcastAddr, err := net.ResolveUDPAddr("udp4", BroadcastAddr)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "[Discovery] Failed to resolve broadcast address: %v\n", err)
-		return
+	d.backendsMu.RLock()
+	defer d.backendsMu.RUnlock()
+	for _, b := range d.backends {
+		b.Stop()
 	}
+}
 
-	// Bind to any local address
-	localAddr := &net.UDPAddr{IP: net.IPv4zero, Port: 0}
-	conn, err := net.DialUDP("udp4", localAddr, broadcastAddr)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "[Discovery] Failed to create broadcast socket: %v\n", err)
-		return
+// SwitchBackends stops the current backends and starts a new set in their
+// place, used by the DISCOVERY_MODE command to change transport at runtime.
+func (d *Discovery) SwitchBackends(backends ...Backend) error {
+	d.backendsMu.Lock()
+	old := d.backends
+	d.backends = backends
+	d.backendsMu.Unlock()
+
+	for _, b := range old {
+		b.Stop()
 	}
-	defer conn.Close()
 
-	fmt.Println("[Discovery] Beacon started, broadcasting every 1s...")
+	d.peersMu.Lock()
+	d.peers = make(map[string]*Peer)
+	d.peersMu.Unlock()
 
-	ticker := time.NewTicker(BeaconInterval)
-	defer ticker.Stop()
+	for _, b := range backends {
+		if err := b.Start(d.state, d.handlePeer); err != nil {
+			return fmt.Errorf("failed to start backend: %v", err)
+		}
+	}
+	return nil
+}
 
-	for {
-		select {
-		case <-d.stopChan:
-			return
-		case <-ticker.C:
-			// Build packet with current grab state
-			d.grabMu.RLock()
-			packet := BeaconPacket{
-				DeviceID:    d.deviceID,
-				DeviceName:  d.deviceName,
-				ServicePort: ServicePort,
-				IsHolding:   d.isHolding,
-				HeldFile:    d.heldFile,
-			}
-			d.grabMu.RUnlock()
+// state returns a snapshot of what we currently advertise, passed to
+// backends as the onState callback.
+func (d *Discovery) state() State {
+	d.grabMu.RLock()
+	defer d.grabMu.RUnlock()
+	return State{
+		DeviceID:    d.deviceID,
+		DeviceName:  d.deviceName,
+		ServicePort: ServicePort,
+		Fingerprint: d.fingerprint,
+		PublicKey:   d.publicKey,
+		IsHolding:   d.isHolding,
+		HeldFile:    d.heldFile,
+		HeldType:    d.heldTypeLocked(),
+		HeldSize:    d.heldSize,
+		HeldCount:   d.heldCount,
+	}
+}
 
-			data, err := json.Marshal(packet)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "[Discovery] Failed to marshal beacon: %v\n", err)
-				continue
-			}
+// heldTypeLocked reports the current grab's type as "file" or "dir", or ""
+// when nothing is held. Callers must hold grabMu.
+func (d *Discovery) heldTypeLocked() string {
+	if d.heldFile == "" {
+		return ""
+	}
+	return heldTypeString(d.heldIsDir)
+}
 
-			_, err = conn.Write(data)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "[Discovery] Beacon send error: %v\n", err)
-			}
-		}
+// heldTypeString is the wire representation of whether a grab is a single
+// file or a directory.
+func heldTypeString(isDir bool) string {
+	if isDir {
+		return "dir"
 	}
+	return "file"
 }
 
-// startListener listens for beacon packets from other devices
-func (d *Discovery) startListener() {
-	// Use udp4 explicitly for Windows compatibility
-	addr := &net.UDPAddr{
-		Port: DiscoveryPort,
-		IP:   net.IPv4zero,
+// handlePeer merges a peer sighting from any backend into the combined
+// view, deduplicating by DeviceID, and emits peer_joined/grab_started/
+// grab_released events on change.
+func (d *Discovery) handlePeer(peer Peer) {
+	if peer.ID == d.deviceID {
+		return // ignore our own announcements
 	}
+	peer.lastSeen = time.Now()
 
-	conn, err := net.ListenUDP("udp4", addr)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "[Discovery] Failed to start listener on port %d: %v\n", DiscoveryPort, err)
-		fmt.Fprintf(os.Stderr, "[Discovery] This may be due to firewall or another process using the port.\n")
+	d.peersMu.Lock()
+	existing, exists := d.peers[peer.ID]
+	d.peers[peer.ID] = &peer
+	d.peersMu.Unlock()
+
+	if !exists {
+		fmt.Printf("[PEER_FOUND] %s (%s)\n", peer.Name, peer.ID)
+		d.publish(events.Event{Type: events.PeerJoined, Data: peer})
 		return
 	}
-	defer conn.Close()
 
-	fmt.Printf("[Discovery] Listener started on port %d\n", DiscoveryPort)
+	if existing.IsHolding != peer.IsHolding || existing.HeldFile != peer.HeldFile {
+		fmt.Printf("[GRAB_UPDATE] %s (%s)\n", peer.Name, peer.ID)
+		if peer.IsHolding {
+			d.publish(events.Event{Type: events.GrabStarted, Data: peer})
+		} else {
+			d.publish(events.Event{Type: events.GrabReleased, Data: peer})
+		}
+	}
+}
 
-	buffer := make([]byte, 1024)
+// reapStaleLoop drops (and announces the departure of) any peer we haven't
+// heard from on any backend in over peerTimeout.
+func (d *Discovery) reapStaleLoop() {
+	ticker := time.NewTicker(BeaconInterval)
+	defer ticker.Stop()
 
 	for {
 		select {
-		case <-d.stopChan:
+		case <-d.reapStop:
 			return
-		default:
-			conn.SetReadDeadline(time.Now().Add(2 * time.Second))
-			n, remoteAddr, err := conn.ReadFromUDP(buffer)
-			if err != nil {
-				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-					continue
-				}
-				continue
-			}
-
-			var packet BeaconPacket
-			if err := json.Unmarshal(buffer[:n], &packet); err != nil {
-				continue
-			}
-
-			// Ignore our own broadcasts
-			if packet.DeviceID == d.deviceID {
-				continue
-			}
-
-			// Handle peer update
+		case <-ticker.C:
 			d.peersMu.Lock()
-			existingPeer, exists := d.peers[packet.DeviceID]
-			
-			peer := &Peer{
-				ID:        packet.DeviceID,
-				IP:        remoteAddr.IP.String(),
-				Name:      packet.DeviceName,
-				IsHolding: packet.IsHolding,
-				HeldFile:  packet.HeldFile,
-			}
-			d.peers[packet.DeviceID] = peer
-
-			if !exists {
-				// New peer discovered
-				peerJSON, _ := json.Marshal(peer)
-				fmt.Printf("[PEER_FOUND] %s\n", string(peerJSON))
-			} else if existingPeer.IsHolding != packet.IsHolding || existingPeer.HeldFile != packet.HeldFile {
-				// Grab state changed - emit update
-				peerJSON, _ := json.Marshal(peer)
-				fmt.Printf("[GRAB_UPDATE] %s\n", string(peerJSON))
+			now := time.Now()
+			for id, peer := range d.peers {
+				if now.Sub(peer.lastSeen) > peerTimeout {
+					delete(d.peers, id)
+					fmt.Printf("[PEER_LOST] %s\n", id)
+					d.publish(events.Event{Type: events.PeerLeft, Data: peer})
+				}
 			}
 			d.peersMu.Unlock()
 		}
 	}
 }
 
+// Events returns the stream of peer and grab-state events. It is shared by
+// every consumer (stdout [EVENT] logging, the /events WebSocket broadcast)
+// so publish is non-blocking - a slow consumer never stalls discovery.
+func (d *Discovery) Events() <-chan events.Event {
+	return d.eventsCh
+}
+
+func (d *Discovery) publish(ev events.Event) {
+	select {
+	case d.eventsCh <- ev:
+	default:
+	}
+}
+
 // GetDeviceID returns this device's unique ID
 func (d *Discovery) GetDeviceID() string {
 	return d.deviceID
@@ -215,43 +289,66 @@ func (d *Discovery) GetDeviceName() string {
 	return d.deviceName
 }
 
-// SetGrab starts holding a file
-func (d *Discovery) SetGrab(filename string) {
+// SetGrab starts holding a file or directory. name is resolved by the
+// caller the same way /file/ and /dir/ resolve names against the shared
+// directory; isDir, totalSize and fileCount describe what's actually being
+// shared (fileCount is 1 for a plain file) so peers see a real grab
+// descriptor instead of a bare filename. The grab_started event is pushed
+// to subscribers, and every backend is told to announce immediately rather
+// than waiting for its own refresh interval.
+func (d *Discovery) SetGrab(name string, isDir bool, totalSize int64, fileCount int) {
 	d.grabMu.Lock()
 	d.isHolding = true
-	d.heldFile = filename
+	d.heldFile = name
+	d.heldIsDir = isDir
+	d.heldSize = totalSize
+	d.heldCount = fileCount
 	d.grabMu.Unlock()
-	fmt.Printf("[Discovery] Now holding: %s\n", filename)
+	fmt.Printf("[Discovery] Now holding: %s\n", name)
+
+	d.announceNow()
+	d.publish(events.Event{Type: events.GrabStarted, Data: &Peer{
+		ID: d.deviceID, Name: d.deviceName, IsHolding: true,
+		HeldFile: name, HeldType: heldTypeString(isDir), HeldSize: totalSize, HeldCount: fileCount,
+	}})
 }
 
-// ClearGrab releases the held file
+// ClearGrab releases the held file or directory, announcing and pushing
+// grab_released immediately.
 func (d *Discovery) ClearGrab() {
 	d.grabMu.Lock()
 	d.isHolding = false
 	d.heldFile = ""
+	d.heldIsDir = false
+	d.heldSize = 0
+	d.heldCount = 0
 	d.grabMu.Unlock()
 	fmt.Println("[Discovery] Released file")
+
+	d.announceNow()
+	d.publish(events.Event{Type: events.GrabReleased, Data: &Peer{
+		ID: d.deviceID, Name: d.deviceName, IsHolding: false,
+	}})
 }
 
-// IsHolding returns current grab state
-func (d *Discovery) IsHolding() (bool, string) {
+// IsHolding returns current grab state: whether we're holding anything, its
+// name, whether it's a directory, its total size, and its file count.
+func (d *Discovery) IsHolding() (isHolding bool, name string, isDir bool, totalSize int64, fileCount int) {
 	d.grabMu.RLock()
 	defer d.grabMu.RUnlock()
-	return d.isHolding, d.heldFile
+	return d.isHolding, d.heldFile, d.heldIsDir, d.heldSize, d.heldCount
+}
+
+func (d *Discovery) announceNow() {
+	state := d.state()
+	d.backendsMu.RLock()
+	defer d.backendsMu.RUnlock()
+	for _, b := range d.backends {
+		b.Announce(state)
+	}
 }
 
 // GetLocalIP returns the local IP address
 func GetLocalIP() string {
-	addrs, err := net.InterfaceAddrs()
-	if err != nil {
-		return "127.0.0.1"
-	}
-	for _, addr := range addrs {
-		if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
-			if ipnet.IP.To4() != nil {
-				return ipnet.IP.String()
-			}
-		}
-	}
-	return "127.0.0.1"
+	return getLocalIP()
 }
@@ -0,0 +1,205 @@
+package discovery
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/mdns"
+)
+
+const (
+	mdnsService    = "_airshare._tcp"
+	mdnsDomain     = "local."
+	mdnsQueryEvery = BeaconInterval
+)
+
+// MDNS discovers peers via mDNS/DNS-SD instead of UDP broadcast, which
+// many enterprise and mobile-hotspot networks filter. It advertises
+// _airshare._tcp with our State packed into TXT records and periodically
+// browses for other instances of the same service.
+type MDNS struct {
+	server   *mdns.Server
+	stopChan chan struct{}
+
+	mu      sync.Mutex
+	onState func() State
+}
+
+// NewMDNSBackend creates an mDNS/DNS-SD discovery backend.
+func NewMDNSBackend() *MDNS {
+	return &MDNS{stopChan: make(chan struct{})}
+}
+
+// Start implements Backend.
+func (m *MDNS) Start(onState func() State, onPeer func(Peer)) error {
+	m.onState = onState
+
+	state := onState()
+	service, err := mdns.NewMDNSService(
+		state.DeviceID,
+		mdnsService,
+		mdnsDomain,
+		"",
+		state.ServicePort,
+		nil,
+		txtRecords(state),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to build mdns service: %v", err)
+	}
+
+	server, err := mdns.NewServer(&mdns.Config{Zone: service})
+	if err != nil {
+		return fmt.Errorf("failed to start mdns server: %v", err)
+	}
+	m.server = server
+
+	go m.browseLoop(onPeer)
+
+	return nil
+}
+
+// Stop implements Backend.
+func (m *MDNS) Stop() {
+	close(m.stopChan)
+	if m.server != nil {
+		m.server.Shutdown()
+	}
+}
+
+// Peers implements Backend. MDNS reports peers directly to the onPeer
+// callback as each browse cycle finds them, rather than keeping its own
+// cache.
+func (m *MDNS) Peers() []Peer {
+	return nil
+}
+
+// Announce implements Backend. It re-registers the mDNS service so queries
+// are answered with an updated TXT record (grab state) from now on; peers
+// pick the change up on their next browse cycle.
+func (m *MDNS) Announce(state State) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.server == nil {
+		return
+	}
+	service, err := mdns.NewMDNSService(state.DeviceID, mdnsService, mdnsDomain, "", state.ServicePort, nil, txtRecords(state))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[MDNS] Failed to rebuild service record: %v\n", err)
+		return
+	}
+	m.server.Shutdown()
+	server, err := mdns.NewServer(&mdns.Config{Zone: service})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[MDNS] Failed to restart server: %v\n", err)
+		return
+	}
+	m.server = server
+}
+
+func (m *MDNS) browseLoop(onPeer func(Peer)) {
+	ticker := time.NewTicker(mdnsQueryEvery)
+	defer ticker.Stop()
+
+	self := m.onState().DeviceID
+
+	for {
+		select {
+		case <-m.stopChan:
+			return
+		case <-ticker.C:
+			// mdns.Query never closes entries once it returns, so the drain
+			// goroutine below needs its own exit signal instead of relying
+			// on the range loop ending on its own - otherwise it leaks,
+			// blocked on the channel forever, once every mdnsQueryEvery.
+			entries := make(chan *mdns.ServiceEntry, 16)
+			done := make(chan struct{})
+			go func() {
+				for {
+					select {
+					case entry, ok := <-entries:
+						if !ok {
+							return
+						}
+						peer, ok := peerFromEntry(entry, self)
+						if ok {
+							onPeer(peer)
+						}
+					case <-done:
+						return
+					}
+				}
+			}()
+			mdns.Query(&mdns.QueryParam{
+				Service: mdnsService,
+				Domain:  mdnsDomain,
+				Timeout: 2 * time.Second,
+				Entries: entries,
+			})
+			close(done)
+		}
+	}
+}
+
+// txtRecords packs State into the key=value TXT record pairs mDNS/DNS-SD
+// expects.
+func txtRecords(state State) []string {
+	return []string{
+		"deviceId=" + state.DeviceID,
+		"deviceName=" + state.DeviceName,
+		"fingerprint=" + state.Fingerprint,
+		"publicKey=" + state.PublicKey,
+		"isHolding=" + strconv.FormatBool(state.IsHolding),
+		"heldFile=" + state.HeldFile,
+		"heldType=" + state.HeldType,
+		"heldSize=" + strconv.FormatInt(state.HeldSize, 10),
+		"heldCount=" + strconv.Itoa(state.HeldCount),
+	}
+}
+
+// peerFromEntry turns a resolved mDNS service entry into a Peer, skipping
+// our own advertisement.
+func peerFromEntry(entry *mdns.ServiceEntry, selfID string) (Peer, bool) {
+	fields := make(map[string]string, len(entry.InfoFields))
+	for _, f := range entry.InfoFields {
+		for i := 0; i < len(f); i++ {
+			if f[i] == '=' {
+				fields[f[:i]] = f[i+1:]
+				break
+			}
+		}
+	}
+
+	deviceID := fields["deviceId"]
+	if deviceID == "" || deviceID == selfID {
+		return Peer{}, false
+	}
+
+	ip := ""
+	switch {
+	case entry.AddrV4 != nil:
+		ip = entry.AddrV4.String()
+	case entry.AddrV6 != nil:
+		ip = entry.AddrV6.String()
+	}
+
+	heldSize, _ := strconv.ParseInt(fields["heldSize"], 10, 64)
+	heldCount, _ := strconv.Atoi(fields["heldCount"])
+
+	return Peer{
+		ID:          deviceID,
+		IP:          ip,
+		Name:        fields["deviceName"],
+		Fingerprint: fields["fingerprint"],
+		PublicKey:   fields["publicKey"],
+		IsHolding:   fields["isHolding"] == "true",
+		HeldFile:    fields["heldFile"],
+		HeldType:    fields["heldType"],
+		HeldSize:    heldSize,
+		HeldCount:   heldCount,
+	}, true
+}
@@ -0,0 +1,174 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	DiscoveryPort = 9988
+	BroadcastAddr = "255.255.255.255:9988"
+)
+
+// beaconPacket is the wire format UDPBroadcast sends: State itself, encoded
+// as JSON.
+type beaconPacket = State
+
+// UDPBroadcast is the original discovery transport: each device broadcasts
+// its State as a UDP packet every BeaconInterval and listens for the same
+// from everyone else. It's blocked by firewalls on many enterprise and
+// mobile-hotspot networks, which is why MDNS exists as an alternative.
+type UDPBroadcast struct {
+	conn     *net.UDPConn
+	stopChan chan struct{}
+
+	stateMu sync.RWMutex
+	onState func() State
+}
+
+// NewUDPBroadcastBackend creates a UDP broadcast discovery backend.
+func NewUDPBroadcastBackend() *UDPBroadcast {
+	return &UDPBroadcast{stopChan: make(chan struct{})}
+}
+
+// Start implements Backend.
+func (u *UDPBroadcast) Start(onState func() State, onPeer func(Peer)) error {
+	u.onState = onState
+
+	addr := &net.UDPAddr{Port: DiscoveryPort, IP: net.IPv4zero}
+	conn, err := net.ListenUDP("udp4", addr)
+	if err != nil {
+		return fmt.Errorf("failed to start listener on port %d: %v", DiscoveryPort, err)
+	}
+	u.conn = conn
+
+	go u.broadcastLoop()
+	go u.listenLoop(onPeer)
+
+	return nil
+}
+
+// Stop implements Backend.
+func (u *UDPBroadcast) Stop() {
+	close(u.stopChan)
+	if u.conn != nil {
+		u.conn.Close()
+	}
+}
+
+// Peers implements Backend. UDPBroadcast reports peers directly to the
+// onPeer callback as they arrive rather than keeping its own cache.
+func (u *UDPBroadcast) Peers() []Peer {
+	return nil
+}
+
+// Announce implements Backend. It sends one out-of-band beacon carrying
+// state right away, so a SetGrab/ClearGrab change reaches peers before the
+// next BeaconInterval tick.
+func (u *UDPBroadcast) Announce(state State) {
+	u.sendBeacon(state)
+}
+
+func (u *UDPBroadcast) broadcastLoop() {
+	broadcastAddr, err := net.ResolveUDPAddr("udp4", BroadcastAddr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[UDPBroadcast] Failed to resolve broadcast address: %v\n", err)
+		return
+	}
+
+	localAddr := &net.UDPAddr{IP: net.IPv4zero, Port: 0}
+	sendConn, err := net.DialUDP("udp4", localAddr, broadcastAddr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[UDPBroadcast] Failed to create broadcast socket: %v\n", err)
+		return
+	}
+	defer sendConn.Close()
+
+	fmt.Println("[UDPBroadcast] Beacon started, broadcasting every", BeaconInterval)
+
+	ticker := time.NewTicker(BeaconInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-u.stopChan:
+			return
+		case <-ticker.C:
+			u.writeBeacon(sendConn)
+		}
+	}
+}
+
+func (u *UDPBroadcast) sendBeacon(state State) {
+	broadcastAddr, err := net.ResolveUDPAddr("udp4", BroadcastAddr)
+	if err != nil {
+		return
+	}
+	conn, err := net.DialUDP("udp4", nil, broadcastAddr)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return
+	}
+	conn.Write(data)
+}
+
+func (u *UDPBroadcast) writeBeacon(conn *net.UDPConn) {
+	data, err := json.Marshal(u.onState())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[UDPBroadcast] Failed to marshal beacon: %v\n", err)
+		return
+	}
+	if _, err := conn.Write(data); err != nil {
+		fmt.Fprintf(os.Stderr, "[UDPBroadcast] Beacon send error: %v\n", err)
+	}
+}
+
+func (u *UDPBroadcast) listenLoop(onPeer func(Peer)) {
+	fmt.Printf("[UDPBroadcast] Listener started on port %d\n", DiscoveryPort)
+
+	buffer := make([]byte, 1024)
+	self := u.onState()
+
+	for {
+		select {
+		case <-u.stopChan:
+			return
+		default:
+			u.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			n, remoteAddr, err := u.conn.ReadFromUDP(buffer)
+			if err != nil {
+				continue
+			}
+
+			var packet beaconPacket
+			if err := json.Unmarshal(buffer[:n], &packet); err != nil {
+				continue
+			}
+			if packet.DeviceID == self.DeviceID {
+				continue
+			}
+
+			onPeer(Peer{
+				ID:          packet.DeviceID,
+				IP:          remoteAddr.IP.String(),
+				Name:        packet.DeviceName,
+				Fingerprint: packet.Fingerprint,
+				PublicKey:   packet.PublicKey,
+				IsHolding:   packet.IsHolding,
+				HeldFile:    packet.HeldFile,
+				HeldType:    packet.HeldType,
+				HeldSize:    packet.HeldSize,
+				HeldCount:   packet.HeldCount,
+			})
+		}
+	}
+}
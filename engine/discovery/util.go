@@ -0,0 +1,21 @@
+package discovery
+
+import "net"
+
+// getLocalIP returns the first non-loopback IPv4 address bound to this
+// machine, used for display and as this device's LAN address when
+// registering with a relay coordinator.
+func getLocalIP() string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return "127.0.0.1"
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
+			if ipnet.IP.To4() != nil {
+				return ipnet.IP.String()
+			}
+		}
+	}
+	return "127.0.0.1"
+}
@@ -0,0 +1,25 @@
+// Package events defines the typed event envelope streamed to peers over
+// /events and printed as structured [EVENT] stdout lines for the Tauri
+// sidecar, shared by the discovery and server packages so both can publish
+// onto the same stream without depending on each other.
+package events
+
+// Type identifies the kind of event flowing through the /events stream.
+type Type string
+
+const (
+	PeerJoined       Type = "peer_joined"
+	PeerLeft         Type = "peer_left"
+	GrabStarted      Type = "grab_started"
+	GrabReleased     Type = "grab_released"
+	TransferProgress Type = "transfer_progress"
+	TransferComplete Type = "transfer_complete"
+)
+
+// Event is the JSON shape streamed to /events subscribers. Data is left as
+// an interface{} since its shape varies by Type (a Peer, a progress count,
+// and so on).
+type Event struct {
+	Type Type        `json:"type"`
+	Data interface{} `json:"data,omitempty"`
+}
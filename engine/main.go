@@ -2,26 +2,81 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"strings"
 	"syscall"
 
+	"airshare-engine/auth"
 	"airshare-engine/discovery"
+	"airshare-engine/relay"
 	"airshare-engine/server"
 )
 
+var (
+	relayMode = flag.Bool("relay", false, "run as a public relay/rendezvous coordinator instead of a peer")
+	relayAddr = flag.String("relay-addr", ":9999", "listen address to use when running with --relay")
+	relayURL  = flag.String("relay-url", "", "rendezvous coordinator to register with for NAT traversal, e.g. ws://relay.example.com:9999")
+)
+
+// listFilesMaxDepth bounds how deep LIST_FILES recurses into the shared
+// directory, so a deeply nested tree can't flood the stdin/stdout command
+// protocol with thousands of lines.
+const listFilesMaxDepth = 3
+
 var disc *discovery.Discovery
 var srv *server.Server
+var identity *auth.Identity
+var trust *auth.TrustStore
+var pairing *auth.Pairing
+var relayClient *relay.Client
 
 func main() {
+	flag.Parse()
+
+	if *relayMode {
+		fmt.Printf("[INFO] Running as relay coordinator on %s\n", *relayAddr)
+		if err := relay.NewServer().ListenAndServe(*relayAddr); err != nil {
+			fmt.Fprintf(os.Stderr, "[ERROR] Relay coordinator exited: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Initial greeting to stdout - will be captured by Tauri sidecar
 	fmt.Println("Hello from AirShare Go Engine!")
 
-	// Start the discovery service
-	disc = discovery.New()
+	var err error
+	identity, err = auth.LoadOrCreateIdentity()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] Failed to load identity: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("[INFO] Fingerprint: %s\n", identity.Fingerprint())
+
+	trust, err = auth.LoadTrustStore()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] Failed to load trust store: %v\n", err)
+		os.Exit(1)
+	}
+
+	pairing, err = auth.NewPairing(identity)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] Failed to initialize pairing: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Start the discovery service. UDP broadcast is the default backend;
+	// DISCOVERY_MODE switches to mDNS (or both) at runtime for networks
+	// that filter broadcast traffic.
+	disc = discovery.New(identity.Fingerprint(), identity.PublicKeyHex(), discovery.NewUDPBroadcastBackend())
 	fmt.Printf("[INFO] Device ID: %s\n", disc.GetDeviceID())
 	fmt.Printf("[INFO] Device Name: %s\n", disc.GetDeviceName())
 	fmt.Printf("[INFO] Local IP: %s\n", discovery.GetLocalIP())
@@ -33,13 +88,33 @@ func main() {
 	}
 
 	// Start HTTP file server
-	srv = server.New()
+	srv = server.New(trust, pairing)
+	srv.SetGrabProvider(disc.IsHolding)
 	if err := srv.Start(); err != nil {
 		fmt.Fprintf(os.Stderr, "[ERROR] Failed to start server: %v\n", err)
 	}
 
+	// Registering with a relay coordinator is opt-in: it's only needed to
+	// reach a peer outside our broadcast domain, via DownloadManifestFromPeer
+	// or PAIR_REMOTE.
+	if *relayURL != "" {
+		lanAddr := fmt.Sprintf("%s:%d", discovery.GetLocalIP(), server.ServerPort)
+		relayClient, err = relay.Dial(*relayURL, disc.GetDeviceID(), identity.PublicKeyHex(), lanAddr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[ERROR] Failed to register with relay %s: %v\n", *relayURL, err)
+		} else {
+			srv.SetRelayClient(relayClient)
+			relayClient.SetHandler(srv.Handler())
+			fmt.Printf("[INFO] Registered with relay at %s\n", *relayURL)
+		}
+	}
+
 	fmt.Println("[INFO] AirShare engine running. Waiting for commands...")
 
+	// Fan discovery's peer/grab events out to stdout (for the Tauri
+	// sidecar) and to the /events WebSocket broadcast
+	go forwardEvents()
+
 	// Start stdin command listener in goroutine
 	go listenForCommands()
 
@@ -52,10 +127,24 @@ func main() {
 	disc.Stop()
 }
 
+// forwardEvents relays Discovery's peer/grab event stream to stdout as
+// structured [EVENT] lines and onward to the server's /events subscribers,
+// so transfer progress published by the server lands on the same feed.
+func forwardEvents() {
+	for ev := range disc.Events() {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			continue
+		}
+		fmt.Printf("[EVENT] %s\n", data)
+		srv.Publish(ev)
+	}
+}
+
 // listenForCommands reads stdin for commands from Tauri
 func listenForCommands() {
 	scanner := bufio.NewScanner(os.Stdin)
-	
+
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
 		if line == "" {
@@ -68,9 +157,14 @@ func listenForCommands() {
 		switch cmd {
 		case "GRAB":
 			if len(parts) > 1 {
-				filename := parts[1]
-				disc.SetGrab(filename)
-				fmt.Printf("[CMD] Grab started: %s\n", filename)
+				name := parts[1]
+				isDir, totalSize, fileCount, err := srv.DescribeGrab(name)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "[ERROR] Grab failed: %v\n", err)
+					continue
+				}
+				disc.SetGrab(name, isDir, totalSize, fileCount)
+				fmt.Printf("[CMD] Grab started: %s\n", name)
 			}
 
 		case "RELEASE":
@@ -79,13 +173,14 @@ func listenForCommands() {
 
 		case "DOWNLOAD":
 			if len(parts) > 1 {
-				// Format: DOWNLOAD http://ip:port/file/name dest_path
-				args := strings.SplitN(parts[1], " ", 2)
-				if len(args) == 2 {
-					url := args[0]
+				// Format: DOWNLOAD http://ip:port/manifest/name dest_path token
+				args := strings.SplitN(parts[1], " ", 3)
+				if len(args) == 3 {
+					manifestURL := args[0]
 					destPath := args[1]
+					token := args[2]
 					go func() {
-						if err := srv.DownloadFile(url, destPath); err != nil {
+						if err := srv.DownloadManifest(manifestURL, destPath, token); err != nil {
 							fmt.Fprintf(os.Stderr, "[ERROR] Download failed: %v\n", err)
 						} else {
 							fmt.Printf("[DOWNLOAD_COMPLETE] %s\n", destPath)
@@ -94,15 +189,181 @@ func listenForCommands() {
 				}
 			}
 
+		case "DOWNLOAD_REMOTE":
+			if len(parts) > 1 {
+				// Format: DOWNLOAD_REMOTE deviceId filename dest_path token
+				args := strings.SplitN(parts[1], " ", 4)
+				if len(args) == 4 {
+					deviceID := args[0]
+					filename := args[1]
+					destPath := args[2]
+					token := args[3]
+					go func() {
+						if err := srv.DownloadManifestFromPeer(deviceID, filename, destPath, token); err != nil {
+							fmt.Fprintf(os.Stderr, "[ERROR] Remote download failed: %v\n", err)
+						} else {
+							fmt.Printf("[DOWNLOAD_COMPLETE] %s\n", destPath)
+						}
+					}()
+				}
+			}
+
 		case "GET_IP":
 			fmt.Printf("[LOCAL_IP] %s\n", discovery.GetLocalIP())
 
 		case "LIST_FILES":
-			// List files in shared directory
-			files, err := filepath.Glob(filepath.Join(srv.GetSharedDir(), "*"))
-			if err == nil {
-				for _, f := range files {
-					fmt.Printf("[FILE] %s\n", filepath.Base(f))
+			// Recurse into the shared directory up to listFilesMaxDepth,
+			// tagging each entry so the UI can render a tree instead of a
+			// flat file list.
+			root := srv.GetSharedDir()
+			filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+				if err != nil || p == root {
+					return nil
+				}
+				rel, err := filepath.Rel(root, p)
+				if err != nil {
+					return nil
+				}
+				rel = filepath.ToSlash(rel)
+				depth := strings.Count(rel, "/") + 1
+
+				if info.IsDir() {
+					if depth > listFilesMaxDepth {
+						return filepath.SkipDir
+					}
+					fmt.Printf("[DIR] %s\n", rel)
+					return nil
+				}
+				if depth <= listFilesMaxDepth {
+					fmt.Printf("[FILE] %s\n", rel)
+				}
+				return nil
+			})
+
+		case "PAIR":
+			if len(parts) > 1 {
+				// Format: PAIR http://ip:port/pair publicKeyHex
+				args := strings.SplitN(parts[1], " ", 2)
+				if len(args) == 2 {
+					pairURL := args[0]
+					publicKeyHex := args[1]
+					fingerprint, sas, err := pairing.Initiate(pairURL, publicKeyHex, nil)
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "[ERROR] Pairing failed: %v\n", err)
+					} else {
+						fmt.Printf("[PAIR_SAS] {\"fingerprint\":%q,\"sas\":%q}\n", fingerprint, sas)
+					}
+				}
+			}
+
+		case "PAIR_REMOTE":
+			if len(parts) > 1 {
+				code := strings.TrimSpace(parts[1])
+				if relayClient == nil {
+					fmt.Fprintln(os.Stderr, "[ERROR] PAIR_REMOTE requires the engine to be started with --relay-url")
+					continue
+				}
+
+				deviceID, err := relayClient.RedeemCode(code)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "[ERROR] PAIR_REMOTE failed: %v\n", err)
+					continue
+				}
+
+				candidate, err := relayClient.Lookup(deviceID)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "[ERROR] PAIR_REMOTE lookup failed: %v\n", err)
+					continue
+				}
+
+				// Dial the peer the same way DownloadManifestFromPeer does,
+				// so pairing works even when there's no direct route.
+				dialClient := &http.Client{
+					Transport: &http.Transport{
+						DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+							return relayClient.DialPeer(ctx, deviceID)
+						},
+					},
+				}
+				pairURL := "http://" + deviceID + "/pair"
+				fingerprint, sas, err := pairing.Initiate(pairURL, candidate.PublicKey, dialClient)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "[ERROR] PAIR_REMOTE pairing failed: %v\n", err)
+				} else {
+					fmt.Printf("[PAIR_SAS] {\"fingerprint\":%q,\"sas\":%q}\n", fingerprint, sas)
+				}
+			}
+
+		case "TRUST":
+			if len(parts) > 1 {
+				args := strings.SplitN(parts[1], " ", 2)
+				fingerprint := args[0]
+				name := ""
+				if len(args) == 2 {
+					name = args[1]
+				}
+				if err := pairing.Confirm(fingerprint, name, trust); err != nil {
+					fmt.Fprintf(os.Stderr, "[ERROR] Trust failed: %v\n", err)
+					continue
+				}
+				fmt.Printf("[CMD] Trusted peer: %s\n", fingerprint)
+
+				// Mint the bearer token this peer needs for DOWNLOAD/
+				// DOWNLOAD_REMOTE right away, so trusting a peer is enough
+				// to start transferring with it without a separate round trip.
+				token, err := trust.IssueToken(fingerprint)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "[ERROR] Failed to issue token: %v\n", err)
+				} else {
+					fmt.Printf("[TOKEN] {\"fingerprint\":%q,\"token\":%q}\n", fingerprint, token)
+				}
+			}
+
+		case "TOKEN":
+			if len(parts) > 1 {
+				fingerprint := strings.TrimSpace(parts[1])
+				token, err := trust.IssueToken(fingerprint)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "[ERROR] Failed to issue token: %v\n", err)
+				} else {
+					fmt.Printf("[TOKEN] {\"fingerprint\":%q,\"token\":%q}\n", fingerprint, token)
+				}
+			}
+
+		case "UNTRUST":
+			if len(parts) > 1 {
+				fingerprint := parts[1]
+				if err := trust.Untrust(fingerprint); err != nil {
+					fmt.Fprintf(os.Stderr, "[ERROR] Untrust failed: %v\n", err)
+				} else {
+					fmt.Printf("[CMD] Untrusted peer: %s\n", fingerprint)
+				}
+			}
+
+		case "LIST_PEERS":
+			for _, peer := range trust.List() {
+				fmt.Printf("[PEER] %s %s\n", peer.Fingerprint, peer.Name)
+			}
+
+		case "DISCOVERY_MODE":
+			if len(parts) > 1 {
+				mode := strings.TrimSpace(parts[1])
+				var backends []discovery.Backend
+				switch mode {
+				case "udp":
+					backends = []discovery.Backend{discovery.NewUDPBroadcastBackend()}
+				case "mdns":
+					backends = []discovery.Backend{discovery.NewMDNSBackend()}
+				case "both":
+					backends = []discovery.Backend{discovery.NewUDPBroadcastBackend(), discovery.NewMDNSBackend()}
+				default:
+					fmt.Fprintf(os.Stderr, "[WARN] Unknown discovery mode: %s\n", mode)
+					continue
+				}
+				if err := disc.SwitchBackends(backends...); err != nil {
+					fmt.Fprintf(os.Stderr, "[ERROR] Failed to switch discovery mode: %v\n", err)
+				} else {
+					fmt.Printf("[CMD] Discovery mode: %s\n", mode)
 				}
 			}
 
@@ -111,4 +372,3 @@ func listenForCommands() {
 		}
 	}
 }
-
@@ -0,0 +1,315 @@
+package relay
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// roundTripTimeout bounds how long Lookup/IssueCode/RedeemCode wait for the
+// coordinator to reply.
+const roundTripTimeout = 10 * time.Second
+
+// observeTimeout bounds how long Dial waits to learn this device's observed
+// public address from the coordinator's UDP reflector before giving up and
+// registering without one (hole punching just won't be available).
+const observeTimeout = 3 * time.Second
+
+// Client is a peer's connection to a relay coordinator: it registers this
+// device's identity and observed addresses, looks up other peers, mints
+// and redeems PAIR_REMOTE codes, and - via DialPeer - tries every NAT
+// traversal strategy AirShare knows about in order.
+type Client struct {
+	conn     *websocket.Conn
+	writeMu  sync.Mutex
+	deviceID string
+
+	waitersMu sync.Mutex
+	waiters   map[uint64]chan frame
+	nextReqID uint64
+
+	udpConn *net.UDPConn // the socket observePublicAddr probed with; reused for hole punching so the NAT mapping it opened stays valid
+	punchCh chan frame
+	handler http.Handler // answers connections accepted over a hole-punched QUIC session or a relay tunnel; nil if SetHandler was never called
+
+	tunnelsMu sync.Mutex
+	tunnels   map[string]*tunnelConn
+}
+
+// Dial connects to a relay coordinator at relayURL (e.g.
+// "ws://relay.example.com:9999") and registers this device. lanAddr is
+// this device's own ip:port on the local network, handed to peers that can
+// reach us directly without ever needing the coordinator again.
+func Dial(relayURL, deviceID, publicKeyHex, lanAddr string) (*Client, error) {
+	u, err := toWebsocketURL(relayURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// Learn our own observed public address via the coordinator's UDP
+	// reflector before registering: under symmetric NAT the mapping is
+	// per-destination-and-protocol, so the WebSocket's TCP connection tells
+	// the coordinator nothing useful about the UDP mapping QUIC hole
+	// punching will actually need. Keep the probed socket around so the
+	// mapping it opened is still the one hole punching dials from.
+	publicAddr, udpConn, err := observePublicAddr(relayURL)
+	if err != nil {
+		fmt.Printf("[Relay] Could not observe public address, hole punching unavailable: %v\n", err)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(u, nil)
+	if err != nil {
+		if udpConn != nil {
+			udpConn.Close()
+		}
+		return nil, fmt.Errorf("failed to connect to relay: %v", err)
+	}
+
+	c := &Client{
+		conn:     conn,
+		deviceID: deviceID,
+		waiters:  make(map[uint64]chan frame),
+		udpConn:  udpConn,
+		punchCh:  make(chan frame, 4),
+		tunnels:  make(map[string]*tunnelConn),
+	}
+
+	if err := c.send(frame{Type: msgRegister, DeviceID: deviceID, PublicKey: publicKeyHex, LANAddr: lanAddr, PublicAddr: publicAddr}); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to register with relay: %v", err)
+	}
+
+	go c.readLoop()
+
+	return c, nil
+}
+
+// observePublicAddr asks the coordinator's UDP reflector (listening on the
+// same host:port as the WebSocket endpoint, just over UDP) what address our
+// packets appear to come from, and returns the socket it probed from so the
+// caller can reuse it for hole punching. Returns a nil *net.UDPConn and a
+// non-nil error if the reflector couldn't be reached; callers should treat
+// that as hole punching being unavailable rather than a fatal error.
+func observePublicAddr(relayURL string) (string, *net.UDPConn, error) {
+	host, err := relayHostPort(relayURL)
+	if err != nil {
+		return "", nil, err
+	}
+
+	raddr, err := net.ResolveUDPAddr("udp", host)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid relay address %q: %v", host, err)
+	}
+
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if _, err := conn.WriteToUDP([]byte(reflectProbe), raddr); err != nil {
+		conn.Close()
+		return "", nil, err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(observeTimeout))
+	buf := make([]byte, 64)
+	n, _, err := conn.ReadFromUDP(buf)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		conn.Close()
+		return "", nil, fmt.Errorf("reflector did not respond: %v", err)
+	}
+
+	return string(buf[:n]), conn, nil
+}
+
+// relayHostPort extracts the coordinator's host:port from a relay URL of
+// any of the forms toWebsocketURL accepts, for use with the UDP reflector
+// which listens on that same host:port.
+func relayHostPort(relayURL string) (string, error) {
+	u, err := url.Parse(relayURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid relay URL: %v", err)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("invalid relay URL: missing host")
+	}
+	return u.Host, nil
+}
+
+// toWebsocketURL accepts either an http(s):// or ws(s):// base and returns
+// the /rendezvous WebSocket URL, so --relay-url can be given in whichever
+// form is handy.
+func toWebsocketURL(base string) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", fmt.Errorf("invalid relay URL: %v", err)
+	}
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	case "ws", "wss":
+	default:
+		return "", fmt.Errorf("unsupported relay URL scheme: %s", u.Scheme)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/rendezvous"
+	return u.String(), nil
+}
+
+func (c *Client) send(f frame) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.conn.WriteJSON(f)
+}
+
+// readLoop dispatches every frame the coordinator sends us: replies to our
+// own round trips, incoming punch notifications, and tunnel relay traffic.
+func (c *Client) readLoop() {
+	for {
+		var f frame
+		if err := c.conn.ReadJSON(&f); err != nil {
+			c.closeAllTunnels(err)
+			return
+		}
+
+		switch f.Type {
+		case msgLookupReply, msgCodeReply, msgRedeemReply:
+			c.waitersMu.Lock()
+			ch, ok := c.waiters[f.ReqID]
+			delete(c.waiters, f.ReqID)
+			c.waitersMu.Unlock()
+			if ok {
+				ch <- f
+			}
+
+		case msgPunch:
+			// A peer is about to hole-punch to us: fire packets back at
+			// their observed address and stand up a listener for the
+			// incoming QUIC session in the background, without blocking
+			// the read loop.
+			go c.respondToPunch(f)
+			select {
+			case c.punchCh <- f:
+			default:
+			}
+
+		case msgTunnelOpen, msgTunnelData, msgTunnelClose:
+			c.dispatchTunnelFrame(f)
+		}
+	}
+}
+
+// roundTrip sends req and waits for the coordinator's reply, matched by a
+// request ID rather than replyType alone: DOWNLOAD and PAIR_REMOTE can
+// trigger concurrent Lookup/IssueCode/RedeemCode calls of the same kind, and
+// keying solely by msgType would let their replies clobber each other.
+func (c *Client) roundTrip(req frame, replyType msgType) (frame, error) {
+	reqID := atomic.AddUint64(&c.nextReqID, 1)
+	req.ReqID = reqID
+
+	ch := make(chan frame, 1)
+	c.waitersMu.Lock()
+	c.waiters[reqID] = ch
+	c.waitersMu.Unlock()
+	defer func() {
+		c.waitersMu.Lock()
+		delete(c.waiters, reqID)
+		c.waitersMu.Unlock()
+	}()
+
+	if err := c.send(req); err != nil {
+		return frame{}, err
+	}
+
+	select {
+	case reply := <-ch:
+		return reply, nil
+	case <-time.After(roundTripTimeout):
+		return frame{}, fmt.Errorf("relay: timed out waiting for %s", replyType)
+	}
+}
+
+// Lookup asks the coordinator for a registered peer's candidate addresses.
+func (c *Client) Lookup(deviceID string) (Candidate, error) {
+	reply, err := c.roundTrip(frame{Type: msgLookup, TargetID: deviceID}, msgLookupReply)
+	if err != nil {
+		return Candidate{}, err
+	}
+	if reply.Error != "" {
+		return Candidate{}, fmt.Errorf("relay lookup: %s", reply.Error)
+	}
+	return Candidate{
+		DeviceID:   deviceID,
+		PublicKey:  reply.PublicKey,
+		LANAddr:    reply.LANAddr,
+		PublicAddr: reply.PublicAddr,
+	}, nil
+}
+
+// IssueCode mints a short PAIR_REMOTE code bound to this device, for
+// sharing out of band with whoever should link to us.
+func (c *Client) IssueCode() (string, error) {
+	reply, err := c.roundTrip(frame{Type: msgIssueCode}, msgCodeReply)
+	if err != nil {
+		return "", err
+	}
+	if reply.Error != "" {
+		return "", fmt.Errorf("relay issue code: %s", reply.Error)
+	}
+	return reply.Code, nil
+}
+
+// RedeemCode resolves a PAIR_REMOTE code to the device ID it was issued to.
+func (c *Client) RedeemCode(code string) (string, error) {
+	reply, err := c.roundTrip(frame{Type: msgRedeemCode, Code: code}, msgRedeemReply)
+	if err != nil {
+		return "", err
+	}
+	if reply.Error != "" {
+		return "", fmt.Errorf("relay redeem code: %s", reply.Error)
+	}
+	return reply.TargetID, nil
+}
+
+// DialPeer establishes a connection to deviceID, trying direct LAN first,
+// then a UDP hole-punched QUIC session, and finally falling back to
+// relaying raw bytes through the coordinator. It returns the first
+// strategy that succeeds.
+func (c *Client) DialPeer(ctx context.Context, deviceID string) (net.Conn, error) {
+	cand, err := c.Lookup(deviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	if cand.LANAddr != "" {
+		if conn, err := net.DialTimeout("tcp", cand.LANAddr, 2*time.Second); err == nil {
+			return conn, nil
+		}
+	}
+
+	if cand.PublicAddr != "" {
+		if conn, err := c.dialQUICHolePunch(ctx, deviceID, cand.PublicAddr); err == nil {
+			return conn, nil
+		}
+	}
+
+	return c.openTunnel(deviceID)
+}
+
+// Close disconnects from the coordinator.
+func (c *Client) Close() error {
+	if c.udpConn != nil {
+		c.udpConn.Close()
+	}
+	return c.conn.Close()
+}
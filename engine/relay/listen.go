@@ -0,0 +1,141 @@
+package relay
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// SetHandler wires in the HTTP handler used to answer connections accepted
+// over a hole-punched QUIC session or a relay tunnel, i.e. server.Server's
+// usual mux. Without a handler, this device can still dial out through
+// DialPeer, but peers reaching it over QUIC or a relay tunnel get no answer.
+func (c *Client) SetHandler(handler http.Handler) {
+	c.handler = handler
+}
+
+// respondToPunch is the mirror image of dialQUICHolePunch: run whenever the
+// coordinator tells us a peer is about to hole-punch to us, it fires the
+// same burst of UDP packets back at their observed address, then listens
+// on that same hole-punched socket for the incoming QUIC session and serves
+// it with our registered HTTP handler. Like dialQUICHolePunch, it reuses
+// c.udpConn (the socket our observed address was registered from) when one
+// exists, since that's the only socket whose NAT mapping the peer was told
+// about.
+func (c *Client) respondToPunch(f frame) {
+	if c.handler == nil {
+		return
+	}
+
+	peerAddr, err := net.ResolveUDPAddr("udp", f.PublicAddr)
+	if err != nil {
+		return
+	}
+
+	udpConn := c.udpConn
+	ephemeral := udpConn == nil
+	if ephemeral {
+		udpConn, err = net.ListenUDP("udp", nil)
+		if err != nil {
+			return
+		}
+		defer udpConn.Close()
+	}
+
+	punchPacket := []byte("airshare-punch")
+	for i := 0; i < punchAttempts; i++ {
+		udpConn.WriteToUDP(punchPacket, peerAddr)
+		time.Sleep(punchInterval)
+	}
+
+	cert, err := ephemeralCert()
+	if err != nil {
+		return
+	}
+	tlsConf := &tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: []string{quicALPN}}
+
+	listener, err := quic.Listen(udpConn, tlsConf, nil)
+	if err != nil {
+		return
+	}
+	defer listener.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*punchDeadline)
+	defer cancel()
+
+	conn, err := listener.Accept(ctx)
+	if err != nil {
+		return
+	}
+
+	for {
+		stream, err := conn.AcceptStream(ctx)
+		if err != nil {
+			return
+		}
+		go http.Serve(&singleConnListener{&quicStreamConn{Stream: stream, conn: conn}}, c.handler)
+	}
+}
+
+// singleConnListener adapts a single already-accepted net.Conn to
+// net.Listener so it can be handed to http.Serve, which otherwise wants a
+// listener that hands out many connections - here each hole-punched QUIC
+// stream stands in for one HTTP/1.1 connection.
+type singleConnListener struct {
+	conn net.Conn
+}
+
+func (l *singleConnListener) Accept() (net.Conn, error) {
+	if l.conn == nil {
+		return nil, fmt.Errorf("singleConnListener: already accepted")
+	}
+	conn := l.conn
+	l.conn = nil
+	return conn, nil
+}
+
+func (l *singleConnListener) Close() error   { return nil }
+func (l *singleConnListener) Addr() net.Addr { return l.conn.LocalAddr() }
+
+// ephemeralCert generates a throwaway self-signed certificate for the QUIC
+// listener. Peer authentication already happened at the Noise/trust-store
+// layer before a transfer is ever attempted, so this only needs to satisfy
+// TLS, not identify us - the dialer connects with InsecureSkipVerify.
+func ephemeralCert() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
@@ -0,0 +1,73 @@
+// Package relay implements the optional rendezvous coordinator used for
+// NAT traversal: it matches two device IDs that don't share a broadcast
+// domain, hands back each side's observed address, mints the short codes
+// PAIR_REMOTE links with, and - as a last resort, when a hole-punched QUIC
+// path can't be established either - relays raw bytes between the two
+// peers itself.
+package relay
+
+// msgType identifies the kind of frame exchanged over the /rendezvous
+// WebSocket connection between a client and the coordinator.
+type msgType string
+
+const (
+	msgRegister    msgType = "register"
+	msgLookup      msgType = "lookup"
+	msgLookupReply msgType = "lookup_reply"
+	msgIssueCode   msgType = "issue_code"
+	msgCodeReply   msgType = "code_reply"
+	msgRedeemCode  msgType = "redeem_code"
+	msgRedeemReply msgType = "redeem_reply"
+	msgPunch       msgType = "punch" // ask the coordinator to notify a peer so both sides fire a UDP packet at once
+	msgTunnelOpen  msgType = "tunnel_open"
+	msgTunnelData  msgType = "tunnel_data"
+	msgTunnelClose msgType = "tunnel_close"
+	msgError       msgType = "error"
+)
+
+// reflectProbe is the fixed UDP payload a client sends to the coordinator's
+// reflector to learn its own observed public address; anything else
+// received on that socket is ignored.
+const reflectProbe = "airshare-observe"
+
+// frame is the envelope every message sent over /rendezvous is wrapped in.
+type frame struct {
+	Type msgType `json:"type"`
+
+	// ReqID is set on a round-trip request (lookup / issue_code /
+	// redeem_code) and echoed back on its reply, so a client with more than
+	// one of the same request type in flight can match each reply to the
+	// caller waiting on it instead of the replies racing each other.
+	ReqID uint64 `json:"reqId,omitempty"`
+
+	// register
+	DeviceID  string `json:"deviceId,omitempty"`
+	PublicKey string `json:"publicKey,omitempty"`
+	LANAddr   string `json:"lanAddr,omitempty"`
+
+	// lookup / lookup_reply / punch / register (register's PublicAddr is
+	// what the client itself observed via the UDP reflector, since the
+	// coordinator's own view - the WebSocket's TCP RemoteAddr - can differ
+	// from the NAT mapping QUIC hole-punching actually needs)
+	TargetID   string `json:"targetId,omitempty"`
+	PublicAddr string `json:"publicAddr,omitempty"`
+
+	// issue_code / code_reply / redeem_code / redeem_reply
+	Code string `json:"code,omitempty"`
+
+	// tunnel_*
+	TunnelID string `json:"tunnelId,omitempty"`
+	Data     []byte `json:"data,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// Candidate is what the coordinator hands back for a looked-up peer: every
+// address DialPeer (the dialer behind DownloadManifestFromPeer) should try,
+// in the order it should try them.
+type Candidate struct {
+	DeviceID   string `json:"deviceId"`
+	PublicKey  string `json:"publicKey"`
+	LANAddr    string `json:"lanAddr,omitempty"`
+	PublicAddr string `json:"publicAddr"`
+}
@@ -0,0 +1,101 @@
+package relay
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// quicALPN is the ALPN protocol both sides of a hole-punched session
+// negotiate; it's only used to satisfy TLS, not to distinguish protocols.
+const quicALPN = "airshare-p2p"
+
+// punchAttempts/punchInterval control how many UDP packets we fire at a
+// peer's observed public address before attempting the QUIC handshake.
+// NAT mappings typically open well before this completes, but firing a
+// burst rather than one packet tolerates the first one or two being
+// dropped before the peer's own mapping exists yet.
+const (
+	punchAttempts = 5
+	punchInterval = 150 * time.Millisecond
+	punchDeadline = 4 * time.Second
+)
+
+// dialQUICHolePunch asks the coordinator to tell deviceID our observed
+// address (msgPunch), fires a burst of UDP packets at its own observed
+// address so both NATs open a mapping for each other's traffic, and then
+// attempts a QUIC handshake over that same hole-punched socket. It reuses
+// c.udpConn, the socket observePublicAddr probed the coordinator's
+// reflector from, when one exists - under symmetric NAT the mapping is
+// per-local-port, so punching from a different socket than the one that
+// was observed would punch a hole the peer was never told about.
+func (c *Client) dialQUICHolePunch(ctx context.Context, deviceID, publicAddr string) (net.Conn, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", publicAddr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid peer address %q: %v", publicAddr, err)
+	}
+
+	udpConn := c.udpConn
+	ephemeral := udpConn == nil
+	if ephemeral {
+		udpConn, err = net.ListenUDP("udp", nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	closeOnErr := func() {
+		if ephemeral {
+			udpConn.Close()
+		}
+	}
+
+	if err := c.send(frame{Type: msgPunch, TargetID: deviceID}); err != nil {
+		closeOnErr()
+		return nil, err
+	}
+
+	punchCtx, cancel := context.WithTimeout(ctx, punchDeadline)
+	defer cancel()
+
+	punchPacket := []byte("airshare-punch")
+	for i := 0; i < punchAttempts; i++ {
+		udpConn.WriteToUDP(punchPacket, udpAddr)
+		select {
+		case <-punchCtx.Done():
+			closeOnErr()
+			return nil, punchCtx.Err()
+		case <-time.After(punchInterval):
+		}
+	}
+
+	tlsConf := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{quicALPN}}
+	conn, err := quic.Dial(punchCtx, udpConn, udpAddr, tlsConf, nil)
+	if err != nil {
+		closeOnErr()
+		return nil, fmt.Errorf("quic hole-punch dial failed: %v", err)
+	}
+
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		conn.CloseWithError(0, "stream open failed")
+		return nil, err
+	}
+
+	return &quicStreamConn{Stream: stream, conn: conn}, nil
+}
+
+// quicStreamConn adapts a single QUIC stream to net.Conn, which is all
+// http.Transport needs to speak HTTP/1.1 over it.
+type quicStreamConn struct {
+	*quic.Stream
+	conn *quic.Conn
+}
+
+func (q *quicStreamConn) LocalAddr() net.Addr  { return q.conn.LocalAddr() }
+func (q *quicStreamConn) RemoteAddr() net.Addr { return q.conn.RemoteAddr() }
+
+var _ net.Conn = (*quicStreamConn)(nil)
@@ -0,0 +1,258 @@
+package relay
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// codeTTL bounds how long a PAIR_REMOTE code issued by IssueCode stays
+// redeemable, so a leaked code can't be used to link devices indefinitely.
+const codeTTL = 10 * time.Minute
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// registered is a peer currently connected to the coordinator over
+// /rendezvous.
+type registered struct {
+	conn *websocket.Conn
+	mu   sync.Mutex // guards writes; gorilla's Conn isn't safe for concurrent writers
+
+	deviceID   string
+	publicKey  string
+	lanAddr    string
+	publicAddr string // host:port as observed by the coordinator, i.e. the NAT-mapped address
+}
+
+func (r *registered) writeJSON(f frame) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.conn.WriteJSON(f)
+}
+
+type codeEntry struct {
+	deviceID string
+	expires  time.Time
+}
+
+// Server is the public rendezvous coordinator run with `airshare-engine
+// --relay`. It matches device IDs that don't share a broadcast domain,
+// hands back each side's observed address, mints and redeems the short
+// codes PAIR_REMOTE links with, and relays raw bytes between two peers as
+// the last-resort transport when neither a direct LAN path nor a
+// hole-punched QUIC session can be established.
+type Server struct {
+	mu    sync.Mutex
+	peers map[string]*registered
+	codes map[string]codeEntry
+}
+
+// NewServer creates a relay coordinator with no registered peers.
+func NewServer() *Server {
+	return &Server{
+		peers: make(map[string]*registered),
+		codes: make(map[string]codeEntry),
+	}
+}
+
+// Handler builds the coordinator's HTTP routes.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rendezvous", s.handleRendezvous)
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	})
+	return mux
+}
+
+// ListenAndServe runs the coordinator, blocking until it exits. Alongside
+// the HTTP/WebSocket listener it starts a UDP reflector on the same
+// host:port so clients can learn their own observed public address - the
+// WebSocket's TCP RemoteAddr isn't a reliable stand-in for the UDP NAT
+// mapping QUIC hole punching needs, particularly under symmetric NAT.
+func (s *Server) ListenAndServe(addr string) error {
+	go func() {
+		if err := listenAndServeUDPReflector(addr); err != nil {
+			fmt.Fprintf(os.Stderr, "[Relay] UDP reflector error: %v\n", err)
+		}
+	}()
+	return http.ListenAndServe(addr, s.Handler())
+}
+
+// listenAndServeUDPReflector answers every packet containing reflectProbe
+// with the sender's own observed address, like a minimal STUN binding
+// response - enough for a client to learn the address its NAT maps its
+// traffic to without needing a full STUN implementation.
+func listenAndServeUDPReflector(addr string) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 64)
+	for {
+		n, remote, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return err
+		}
+		if string(buf[:n]) != reflectProbe {
+			continue
+		}
+		conn.WriteToUDP([]byte(remote.String()), remote)
+	}
+}
+
+// handleRendezvous upgrades to a WebSocket and speaks the frame protocol
+// defined in protocol.go for the lifetime of the connection: one register,
+// then any number of lookups, code issue/redeem, punch requests, and
+// tunnel-relay frames.
+func (s *Server) handleRendezvous(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// reg exists from the start of the connection, not just after
+	// msgRegister, so every write to conn - including replies sent before
+	// this peer has registered - goes through the same mutex-guarded
+	// writeJSON another connection's goroutine also writes through (see
+	// msgPunch/forwardTunnelFrame below). gorilla's Conn allows only one
+	// concurrent writer.
+	reg := &registered{conn: conn}
+	defer func() {
+		if reg.deviceID == "" {
+			return
+		}
+		s.mu.Lock()
+		if s.peers[reg.deviceID] == reg {
+			delete(s.peers, reg.deviceID)
+		}
+		s.mu.Unlock()
+	}()
+
+	for {
+		var f frame
+		if err := conn.ReadJSON(&f); err != nil {
+			return
+		}
+
+		switch f.Type {
+		case msgRegister:
+			// Prefer the address the client itself observed via the UDP
+			// reflector: under symmetric NAT the mapping is per
+			// destination/protocol, so the TCP RemoteAddr of this
+			// WebSocket connection isn't a reliable stand-in for the UDP
+			// mapping hole punching actually needs. Fall back to it only
+			// if the client couldn't reach the reflector at all.
+			publicAddr := f.PublicAddr
+			if publicAddr == "" {
+				publicAddr = r.RemoteAddr
+			}
+			reg.deviceID = f.DeviceID
+			reg.publicKey = f.PublicKey
+			reg.lanAddr = f.LANAddr
+			reg.publicAddr = publicAddr
+			s.mu.Lock()
+			s.peers[f.DeviceID] = reg
+			s.mu.Unlock()
+
+		case msgLookup:
+			s.mu.Lock()
+			target, ok := s.peers[f.TargetID]
+			s.mu.Unlock()
+
+			reply := frame{Type: msgLookupReply, ReqID: f.ReqID, TargetID: f.TargetID}
+			if ok {
+				reply.PublicKey = target.publicKey
+				reply.LANAddr = target.lanAddr
+				reply.PublicAddr = target.publicAddr
+			} else {
+				reply.Error = "peer not registered with relay"
+			}
+			reg.writeJSON(reply)
+
+		case msgIssueCode:
+			if reg.deviceID == "" {
+				reg.writeJSON(frame{Type: msgCodeReply, ReqID: f.ReqID, Error: "must register before issuing a code"})
+				continue
+			}
+			code := generateCode()
+			s.mu.Lock()
+			s.codes[code] = codeEntry{deviceID: reg.deviceID, expires: time.Now().Add(codeTTL)}
+			s.mu.Unlock()
+			reg.writeJSON(frame{Type: msgCodeReply, ReqID: f.ReqID, Code: code})
+
+		case msgRedeemCode:
+			s.mu.Lock()
+			entry, ok := s.codes[f.Code]
+			if ok && time.Now().After(entry.expires) {
+				delete(s.codes, f.Code)
+				ok = false
+			}
+			s.mu.Unlock()
+
+			reply := frame{Type: msgRedeemReply, ReqID: f.ReqID}
+			if ok {
+				reply.TargetID = entry.deviceID
+			} else {
+				reply.Error = "unknown or expired code"
+			}
+			reg.writeJSON(reply)
+
+		case msgPunch:
+			s.mu.Lock()
+			target, ok := s.peers[f.TargetID]
+			s.mu.Unlock()
+			if ok {
+				target.writeJSON(frame{Type: msgPunch, TargetID: reg.deviceID, PublicAddr: reg.publicAddr})
+			}
+
+		case msgTunnelOpen, msgTunnelData, msgTunnelClose:
+			s.forwardTunnelFrame(reg, f)
+		}
+	}
+}
+
+// forwardTunnelFrame relays a tunnel_* frame to its TargetID's connection,
+// relabelling TargetID to the sender's own device ID first so the receiving
+// side knows which peer the frame came from. This is the fallback path used
+// when neither a direct LAN dial nor a hole-punched QUIC session reaches
+// the peer.
+func (s *Server) forwardTunnelFrame(reg *registered, f frame) {
+	if reg.deviceID == "" {
+		return
+	}
+	s.mu.Lock()
+	target, ok := s.peers[f.TargetID]
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+	out := f
+	out.TargetID = reg.deviceID
+	target.writeJSON(out)
+}
+
+// generateCode mints a short, human-typeable PAIR_REMOTE code: 5 random
+// bytes base32-encode to exactly 8 characters with no padding.
+func generateCode() string {
+	buf := make([]byte, 5)
+	rand.Read(buf)
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf)
+}
@@ -0,0 +1,176 @@
+package relay
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// tunnelConn is a net.Conn implemented over tunnel_data frames relayed by
+// the coordinator. It's the last-resort transport DialPeer falls back to
+// when neither a direct LAN dial nor a hole-punched QUIC session reaches
+// the peer.
+type tunnelConn struct {
+	client   *Client
+	peerID   string // the relay device ID on the other end of the tunnel
+	tunnelID string
+
+	incoming chan []byte
+	readBuf  []byte
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	closeErr  error
+}
+
+// openTunnel opens a new tunnel to peerID through the coordinator and
+// returns it as a net.Conn.
+func (c *Client) openTunnel(peerID string) (net.Conn, error) {
+	tunnelID := randomTunnelID()
+	t := &tunnelConn{
+		client:   c,
+		peerID:   peerID,
+		tunnelID: tunnelID,
+		incoming: make(chan []byte, 32),
+		closed:   make(chan struct{}),
+	}
+
+	c.tunnelsMu.Lock()
+	c.tunnels[tunnelID] = t
+	c.tunnelsMu.Unlock()
+
+	if err := c.send(frame{Type: msgTunnelOpen, TargetID: peerID, TunnelID: tunnelID}); err != nil {
+		c.forgetTunnel(tunnelID)
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (c *Client) forgetTunnel(tunnelID string) {
+	c.tunnelsMu.Lock()
+	delete(c.tunnels, tunnelID)
+	c.tunnelsMu.Unlock()
+}
+
+// dispatchTunnelFrame routes an inbound tunnel_* frame to its tunnelConn,
+// opening a new one on the receiving side of a peer-initiated tunnel_open
+// and serving it with the handler registered via SetHandler.
+func (c *Client) dispatchTunnelFrame(f frame) {
+	c.tunnelsMu.Lock()
+	t, ok := c.tunnels[f.TunnelID]
+	opened := false
+	if !ok && f.Type == msgTunnelOpen {
+		opened = true
+		t = &tunnelConn{
+			client:   c,
+			peerID:   f.TargetID,
+			tunnelID: f.TunnelID,
+			incoming: make(chan []byte, 32),
+			closed:   make(chan struct{}),
+		}
+		c.tunnels[f.TunnelID] = t
+	}
+	c.tunnelsMu.Unlock()
+
+	if t == nil {
+		return
+	}
+	if opened && c.handler != nil {
+		go http.Serve(&singleConnListener{t}, c.handler)
+	}
+
+	switch f.Type {
+	case msgTunnelData:
+		select {
+		case t.incoming <- f.Data:
+		case <-t.closed:
+		}
+	case msgTunnelClose:
+		t.closeLocal(io.EOF)
+	}
+}
+
+func (c *Client) closeAllTunnels(err error) {
+	c.tunnelsMu.Lock()
+	tunnels := make([]*tunnelConn, 0, len(c.tunnels))
+	for _, t := range c.tunnels {
+		tunnels = append(tunnels, t)
+	}
+	c.tunnelsMu.Unlock()
+
+	for _, t := range tunnels {
+		t.closeLocal(err)
+	}
+}
+
+func (t *tunnelConn) closeLocal(err error) {
+	t.closeOnce.Do(func() {
+		t.closeErr = err
+		close(t.closed)
+		t.client.forgetTunnel(t.tunnelID)
+	})
+}
+
+func (t *tunnelConn) Read(b []byte) (int, error) {
+	for len(t.readBuf) == 0 {
+		select {
+		case chunk, ok := <-t.incoming:
+			if !ok {
+				return 0, io.EOF
+			}
+			t.readBuf = chunk
+		case <-t.closed:
+			if t.closeErr != nil {
+				return 0, t.closeErr
+			}
+			return 0, io.EOF
+		}
+	}
+	n := copy(b, t.readBuf)
+	t.readBuf = t.readBuf[n:]
+	return n, nil
+}
+
+func (t *tunnelConn) Write(b []byte) (int, error) {
+	select {
+	case <-t.closed:
+		return 0, errors.New("relay: tunnel closed")
+	default:
+	}
+	if err := t.client.send(frame{Type: msgTunnelData, TargetID: t.peerID, TunnelID: t.tunnelID, Data: b}); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (t *tunnelConn) Close() error {
+	t.closeLocal(nil)
+	return t.client.send(frame{Type: msgTunnelClose, TargetID: t.peerID, TunnelID: t.tunnelID})
+}
+
+func (t *tunnelConn) LocalAddr() net.Addr              { return tunnelAddr(t.client.deviceID) }
+func (t *tunnelConn) RemoteAddr() net.Addr             { return tunnelAddr(t.peerID) }
+func (t *tunnelConn) SetDeadline(time.Time) error      { return nil }
+func (t *tunnelConn) SetReadDeadline(time.Time) error  { return nil }
+func (t *tunnelConn) SetWriteDeadline(time.Time) error { return nil }
+
+var _ net.Conn = (*tunnelConn)(nil)
+
+// tunnelAddr reports a relayed connection's endpoints as the relevant
+// device ID, since there's no socket address to give.
+type tunnelAddr string
+
+func (a tunnelAddr) Network() string { return "relay-tunnel" }
+func (a tunnelAddr) String() string  { return string(a) }
+
+func randomTunnelID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
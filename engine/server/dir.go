@@ -0,0 +1,210 @@
+package server
+
+import (
+	"archive/tar"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"lukechampine.com/blake3"
+)
+
+// dirEntry describes one file inside a shared directory, as returned by
+// /dir/{name}/index.json so a receiver can show a tree preview and decide
+// whether to fetch the whole tar or a single entry via /dir/{name}/entry.
+type dirEntry struct {
+	Path string `json:"path"` // slash-separated, relative to the directory root
+	Size int64  `json:"size"`
+	Hash string `json:"hash"` // BLAKE3, hex-encoded
+}
+
+// resolveDir joins name onto the shared directory and confirms it's
+// actually a directory, the same way handleFileServe resolves and guards a
+// single file's name.
+func (s *Server) resolveDir(name string) (string, error) {
+	dirPath := filepath.Join(s.sharedDir, filepath.Base(name))
+	info, err := os.Stat(dirPath)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", name)
+	}
+	return dirPath, nil
+}
+
+// DescribeGrab reports whether name - resolved the same way /file/ and
+// /dir/ resolve names - is a file or a directory, along with its total
+// size and file count, so main's GRAB command can pass Discovery an
+// accurate grab descriptor instead of a bare filename.
+func (s *Server) DescribeGrab(name string) (isDir bool, totalSize int64, fileCount int, err error) {
+	path := filepath.Join(s.sharedDir, filepath.Base(name))
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, 0, 0, err
+	}
+	if !info.IsDir() {
+		return false, info.Size(), 1, nil
+	}
+
+	err = filepath.Walk(path, func(_ string, fi os.FileInfo, werr error) error {
+		if werr != nil {
+			return werr
+		}
+		if !fi.IsDir() {
+			totalSize += fi.Size()
+			fileCount++
+		}
+		return nil
+	})
+	return true, totalSize, fileCount, err
+}
+
+// walkDirEntries lists every regular file under dirPath, relative to it,
+// hashing each with BLAKE3 along the way.
+func walkDirEntries(dirPath string) ([]dirEntry, error) {
+	var entries []dirEntry
+	err := filepath.Walk(dirPath, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dirPath, p)
+		if err != nil {
+			return err
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		h := blake3.New(32, nil)
+		if _, err := io.Copy(h, f); err != nil {
+			return err
+		}
+
+		entries = append(entries, dirEntry{
+			Path: filepath.ToSlash(rel),
+			Size: info.Size(),
+			Hash: hex.EncodeToString(h.Sum(nil)),
+		})
+		return nil
+	})
+	return entries, err
+}
+
+// handleDir dispatches the three /dir/ routes based on the path suffix,
+// since they all share the {name} prefix: /dir/{name}.tar,
+// /dir/{name}/index.json, and /dir/{name}/entry?path=....
+func (s *Server) handleDir(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/dir/")
+	switch {
+	case strings.HasSuffix(path, "/index.json"):
+		s.handleDirIndex(w, r, strings.TrimSuffix(path, "/index.json"))
+	case strings.HasSuffix(path, "/entry"):
+		s.handleDirEntry(w, r, strings.TrimSuffix(path, "/entry"))
+	case strings.HasSuffix(path, ".tar"):
+		s.handleDirTar(w, r, strings.TrimSuffix(path, ".tar"))
+	default:
+		http.Error(w, "Unknown directory route", http.StatusNotFound)
+	}
+}
+
+// handleDirIndex serves /dir/{name}/index.json.
+func (s *Server) handleDirIndex(w http.ResponseWriter, r *http.Request, name string) {
+	dirPath, err := s.resolveDir(name)
+	if err != nil {
+		http.Error(w, "Directory not found", http.StatusNotFound)
+		return
+	}
+
+	entries, err := walkDirEntries(dirPath)
+	if err != nil {
+		http.Error(w, "Failed to index directory", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// handleDirTar serves /dir/{name}.tar, streaming the directory as an
+// uncompressed tar built on the fly: each file is copied straight from its
+// own seekable os.File rather than buffered into memory first.
+func (s *Server) handleDirTar(w http.ResponseWriter, r *http.Request, name string) {
+	dirPath, err := s.resolveDir(name)
+	if err != nil {
+		http.Error(w, "Directory not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.tar", name))
+
+	tw := tar.NewWriter(w)
+	defer tw.Close()
+
+	err = filepath.Walk(dirPath, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		rel, err := filepath.Rel(dirPath, p)
+		if err != nil {
+			return err
+		}
+
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[Server] Failed to stream directory tar for %s: %v\n", name, err)
+	}
+}
+
+// handleDirEntry serves /dir/{name}/entry?path=..., a single file from
+// within a shared directory. Range requests work the same way they do for
+// /file/, via http.ServeFile.
+func (s *Server) handleDirEntry(w http.ResponseWriter, r *http.Request, name string) {
+	dirPath, err := s.resolveDir(name)
+	if err != nil {
+		http.Error(w, "Directory not found", http.StatusNotFound)
+		return
+	}
+
+	rel := filepath.Clean(string(filepath.Separator) + r.URL.Query().Get("path"))
+	entryPath := filepath.Join(dirPath, rel)
+
+	info, err := os.Stat(entryPath)
+	if err != nil || info.IsDir() {
+		http.Error(w, "Entry not found", http.StatusNotFound)
+		return
+	}
+
+	http.ServeFile(w, r, entryPath)
+}
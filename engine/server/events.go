@@ -0,0 +1,80 @@
+package server
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/websocket"
+
+	"airshare-engine/events"
+)
+
+// upgrader upgrades /events requests to a WebSocket. AirShare is a LAN-only
+// tool with no browser-hosted origin to guard against, so we accept any
+// origin rather than maintaining an allowlist.
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// eventHub fans published events out to every connected /events subscriber.
+type eventHub struct {
+	mu   sync.Mutex
+	subs map[chan events.Event]struct{}
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subs: make(map[chan events.Event]struct{})}
+}
+
+func (h *eventHub) subscribe() chan events.Event {
+	ch := make(chan events.Event, 16)
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *eventHub) unsubscribe(ch chan events.Event) {
+	h.mu.Lock()
+	delete(h.subs, ch)
+	close(ch)
+	h.mu.Unlock()
+}
+
+// publish fans ev out to every subscriber without blocking on a slow one.
+func (h *eventHub) publish(ev events.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// Publish broadcasts ev to every connected /events subscriber. Discovery's
+// peer/grab events and DownloadManifest's transfer progress both flow
+// through here.
+func (s *Server) Publish(ev events.Event) {
+	s.hub.publish(ev)
+}
+
+// handleEvents upgrades to a WebSocket and streams events as JSON until the
+// client disconnects.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	ch := s.hub.subscribe()
+	defer s.hub.unsubscribe(ch)
+
+	for ev := range ch {
+		if err := conn.WriteJSON(ev); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,364 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"lukechampine.com/blake3"
+
+	"airshare-engine/events"
+)
+
+// BlockSize is the fixed size of each verifiable chunk. 1 MiB keeps the
+// manifest small for multi-GB files while still giving good resume
+// granularity over flaky Wi-Fi.
+const BlockSize = 1 << 20 // 1 MiB
+
+// downloadWorkers bounds how many blocks we fetch in parallel.
+const downloadWorkers = 4
+
+// Manifest describes a shared file as a sequence of fixed-size, hash-verified
+// blocks so a receiver can fetch (and resume fetching) arbitrary ranges.
+type Manifest struct {
+	Filename  string   `json:"filename"`
+	Size      int64    `json:"size"`
+	BlockSize int64    `json:"blockSize"`
+	Blocks    []string `json:"blocks"`   // per-block BLAKE3 hash, hex-encoded
+	RootHash  string   `json:"rootHash"` // BLAKE3 of the concatenated block hashes
+}
+
+// buildManifest hashes filePath in BlockSize chunks and computes a root hash
+// over the concatenation of the block hashes.
+func buildManifest(filePath, filename string) (*Manifest, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{
+		Filename:  filename,
+		Size:      info.Size(),
+		BlockSize: BlockSize,
+	}
+
+	root := blake3.New(32, nil)
+	buf := make([]byte, BlockSize)
+	for {
+		n, err := io.ReadFull(f, buf)
+		if n > 0 {
+			h := blake3.Sum256(buf[:n])
+			hexHash := hex.EncodeToString(h[:])
+			m.Blocks = append(m.Blocks, hexHash)
+			root.Write(h[:])
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	m.RootHash = hex.EncodeToString(root.Sum(nil))
+
+	return m, nil
+}
+
+// handleManifest serves the block manifest for a shared file.
+func (s *Server) handleManifest(w http.ResponseWriter, r *http.Request) {
+	filename := strings.TrimPrefix(r.URL.Path, "/manifest/")
+	filename = filepath.Base(filename)
+
+	if filename == "" || filename == "." {
+		http.Error(w, "Invalid filename", http.StatusBadRequest)
+		return
+	}
+
+	filePath := filepath.Join(s.sharedDir, filename)
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	manifest, err := buildManifest(filePath, filename)
+	if err != nil {
+		http.Error(w, "Failed to build manifest", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(manifest)
+}
+
+// bitmap tracks, one bit per block, which blocks of a partial download are
+// complete. It is persisted alongside the .partial file, prefixed with the
+// manifest's RootHash, so a crash can be resumed by re-reading it instead of
+// re-fetching everything - and so a sidecar left over from a different or
+// changed file (same destPath, same block count, different contents) is
+// detected and discarded instead of being trusted as already-verified.
+type bitmap struct {
+	mu       sync.Mutex
+	path     string
+	rootHash []byte // raw (decoded) manifest.RootHash this bitmap is valid for
+	bits     []byte
+}
+
+func openBitmap(path string, numBlocks int, rootHash string) (*bitmap, error) {
+	root, err := hex.DecodeString(rootHash)
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest root hash: %v", err)
+	}
+
+	size := (numBlocks + 7) / 8
+	b := &bitmap{path: path, rootHash: root, bits: make([]byte, size)}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return b, nil
+	}
+	if len(data) != len(root)+size || !bytes.Equal(data[:len(root)], root) {
+		// Stale or corrupt sidecar - the file at destPath has either changed
+		// or was never the one this manifest describes. Start the bitmap
+		// fresh rather than trusting bits that were verified against a
+		// different RootHash.
+		return b, nil
+	}
+	b.bits = append([]byte(nil), data[len(root):]...)
+	return b, nil
+}
+
+func (b *bitmap) isSet(i int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.bits[i/8]&(1<<uint(i%8)) != 0
+}
+
+func (b *bitmap) set(i int) error {
+	b.mu.Lock()
+	b.bits[i/8] |= 1 << uint(i%8)
+	snapshot := append(append([]byte(nil), b.rootHash...), b.bits...)
+	b.mu.Unlock()
+	return os.WriteFile(b.path, snapshot, 0644)
+}
+
+// DownloadManifest fetches manifestURL, writes into a sparse destPath+".partial"
+// file, and requests any missing blocks in parallel using Range requests
+// against the matching /file/ URL. Each block is verified against its BLAKE3
+// hash before being marked complete in a sidecar bitmap, so an interrupted
+// download resumes by re-reading the bitmap instead of starting over. token
+// is the bearer token issued to this device by the peer's trust store.
+func (s *Server) DownloadManifest(manifestURL string, destPath string, token string) error {
+	return s.downloadManifest(http.DefaultClient, manifestURL, destPath, token)
+}
+
+// DownloadManifestFromPeer behaves like DownloadManifest but resolves the
+// peer through the relay client instead of a known LAN URL: deviceID comes
+// from a relay Lookup or a PAIR_REMOTE redemption, and relay.Client.DialPeer
+// picks whichever of direct LAN, hole-punched QUIC, or the WebSocket relay
+// tunnel reaches it first. Requires SetRelayClient to have been called.
+func (s *Server) DownloadManifestFromPeer(deviceID, filename, destPath, token string) error {
+	if s.relay == nil {
+		return fmt.Errorf("no relay client configured; start the engine with --relay-url")
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return s.relay.DialPeer(ctx, deviceID)
+			},
+		},
+	}
+
+	manifestURL := "http://" + deviceID + "/manifest/" + filename
+	return s.downloadManifest(client, manifestURL, destPath, token)
+}
+
+// downloadManifest is the shared core of DownloadManifest and
+// DownloadManifestFromPeer: everything about the resumable, hash-verified
+// block fetch is identical between a LAN peer and one reached through the
+// relay, the only difference being which http.Client (and therefore which
+// underlying connection) does the talking.
+func (s *Server) downloadManifest(client *http.Client, manifestURL string, destPath string, token string) error {
+	fmt.Printf("[Server] Fetching manifest from %s\n", manifestURL)
+
+	req, err := http.NewRequest(http.MethodGet, manifestURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to build manifest request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch manifest: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("bad manifest status: %s", resp.Status)
+	}
+
+	var manifest Manifest
+	if err := json.NewDecoder(resp.Body).Decode(&manifest); err != nil {
+		return fmt.Errorf("failed to decode manifest: %v", err)
+	}
+
+	fileURL := strings.Replace(manifestURL, "/manifest/", "/file/", 1)
+	partialPath := destPath + ".partial"
+	bitmapPath := destPath + ".bitmap"
+
+	out, err := os.OpenFile(partialPath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create partial file: %v", err)
+	}
+	defer out.Close()
+
+	if err := out.Truncate(manifest.Size); err != nil {
+		return fmt.Errorf("failed to allocate sparse file: %v", err)
+	}
+
+	bm, err := openBitmap(bitmapPath, len(manifest.Blocks), manifest.RootHash)
+	if err != nil {
+		return fmt.Errorf("failed to open bitmap: %v", err)
+	}
+
+	blockIdx := make(chan int)
+	var wg sync.WaitGroup
+	errCh := make(chan error, downloadWorkers)
+	done := make(chan struct{})
+	var doneOnce sync.Once
+
+	var completed int64
+	alreadyDone := int64(countSet(bm, len(manifest.Blocks)))
+	totalBlocks := int64(len(manifest.Blocks))
+
+	for w := 0; w < downloadWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range blockIdx {
+				if err := s.fetchBlock(client, fileURL, token, &manifest, idx, out, bm); err != nil {
+					select {
+					case errCh <- err:
+					default:
+					}
+					doneOnce.Do(func() { close(done) })
+					return
+				}
+				progress := atomic.AddInt64(&completed, 1) + alreadyDone
+				s.Publish(events.Event{Type: events.TransferProgress, Data: map[string]interface{}{
+					"file":      manifest.Filename,
+					"completed": progress,
+					"total":     totalBlocks,
+				}})
+			}
+		}()
+	}
+
+	// Stop feeding blocks as soon as a worker has recorded an error: once
+	// every worker has exited after a shared-nothing peer becomes
+	// unreachable, nothing will ever drain blockIdx again, and without this
+	// select the producer's next send would block forever instead of
+	// surfacing the error already sitting in errCh.
+feed:
+	for idx := range manifest.Blocks {
+		if bm.isSet(idx) {
+			continue
+		}
+		select {
+		case blockIdx <- idx:
+		case <-done:
+			break feed
+		}
+	}
+	close(blockIdx)
+	wg.Wait()
+
+	select {
+	case err := <-errCh:
+		return err
+	default:
+	}
+
+	if err := os.Rename(partialPath, destPath); err != nil {
+		return fmt.Errorf("failed to finalize download: %v", err)
+	}
+	os.Remove(bitmapPath)
+
+	s.Publish(events.Event{Type: events.TransferComplete, Data: map[string]interface{}{
+		"file": manifest.Filename,
+		"path": destPath,
+	}})
+
+	fmt.Printf("[Server] Downloaded successfully: %s\n", destPath)
+	return nil
+}
+
+// countSet returns how many of the first numBlocks bits in bm are already
+// set, used to seed transfer_progress with blocks resumed from a prior run.
+func countSet(bm *bitmap, numBlocks int) int {
+	n := 0
+	for i := 0; i < numBlocks; i++ {
+		if bm.isSet(i) {
+			n++
+		}
+	}
+	return n
+}
+
+// fetchBlock downloads, verifies and writes a single block.
+func (s *Server) fetchBlock(client *http.Client, fileURL, token string, manifest *Manifest, idx int, out *os.File, bm *bitmap) error {
+	start := int64(idx) * manifest.BlockSize
+	end := start + manifest.BlockSize - 1
+	if end > manifest.Size-1 {
+		end = manifest.Size - 1
+	}
+
+	req, err := http.NewRequest(http.MethodGet, fileURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("block %d: %v", idx, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("block %d: bad status %s", idx, resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("block %d: %v", idx, err)
+	}
+
+	sum := blake3.Sum256(data)
+	if hex.EncodeToString(sum[:]) != manifest.Blocks[idx] {
+		return fmt.Errorf("block %d: hash mismatch", idx)
+	}
+
+	if _, err := out.WriteAt(data, start); err != nil {
+		return fmt.Errorf("block %d: write failed: %v", idx, err)
+	}
+
+	return bm.set(idx)
+}
@@ -6,6 +6,10 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
+
+	"airshare-engine/auth"
+	"airshare-engine/relay"
 )
 
 const (
@@ -17,16 +21,77 @@ const (
 type Server struct {
 	sharedDir string
 	port      int
+
+	trust   *auth.TrustStore
+	pairing *auth.Pairing
+	grab    func() (isHolding bool, name string, isDir bool, totalSize int64, fileCount int)
+	hub     *eventHub
+	relay   *relay.Client
 }
 
-// New creates a new file server
-func New() *Server {
+// New creates a new file server. trust and pairing gate access to /file/,
+// /manifest/ and /grab behind the pairing flow implemented by the auth
+// package.
+func New(trust *auth.TrustStore, pairing *auth.Pairing) *Server {
 	return &Server{
 		sharedDir: SharedDir,
 		port:      ServerPort,
+		trust:     trust,
+		pairing:   pairing,
+		hub:       newEventHub(),
 	}
 }
 
+// SetGrabProvider wires in the function used to answer /grab requests with
+// the current hold state. It is set by main once Discovery exists, keeping
+// the server and discovery packages otherwise independent.
+func (s *Server) SetGrabProvider(grab func() (isHolding bool, name string, isDir bool, totalSize int64, fileCount int)) {
+	s.grab = grab
+}
+
+// SetRelayClient wires in the relay connection used by
+// DownloadManifestFromPeer to reach peers outside the local broadcast
+// domain. It is nil, and that code path unavailable, unless the engine was
+// started with --relay-url.
+func (s *Server) SetRelayClient(c *relay.Client) {
+	s.relay = c
+}
+
+// Handler builds the server's HTTP routes. It's used both to serve the
+// plain LAN listener in Start and, over a hole-punched QUIC session, to
+// answer peers reached through the relay - see relay.Client.SetHandler.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	// File serving endpoint - requires a bearer token from a paired peer
+	mux.HandleFunc("/file/", s.requireAuth(s.handleFileServe))
+
+	// Block manifest endpoint, used by DownloadManifest to plan a resumable fetch
+	mux.HandleFunc("/manifest/", s.requireAuth(s.handleManifest))
+
+	// Current grab state, for peers that have already paired
+	mux.HandleFunc("/grab", s.requireAuth(s.handleGrab))
+
+	// Directory grabs: the tar stream, its index, and single-entry fetch
+	mux.HandleFunc("/dir/", s.requireAuth(s.handleDir))
+
+	// Pairing handshake - deliberately NOT behind requireAuth, since a peer
+	// isn't trusted yet when it calls this
+	mux.HandleFunc("/pair", s.handlePair)
+
+	// Push feed of peer/grab/transfer events, for the Tauri sidecar's
+	// "virtual pickup" UX
+	mux.HandleFunc("/events", s.requireAuth(s.handleEvents))
+
+	// Health check
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	})
+
+	return mux
+}
+
 // Start begins the HTTP file server
 func (s *Server) Start() error {
 	// Create shared directory if it doesn't exist
@@ -37,21 +102,10 @@ func (s *Server) Start() error {
 	// Create a demo file for testing
 	s.createDemoFile()
 
-	mux := http.NewServeMux()
-	
-	// File serving endpoint
-	mux.HandleFunc("/file/", s.handleFileServe)
-	
-	// Health check
-	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
-	})
-
 	fmt.Printf("[Server] Starting HTTP server on port %d\n", s.port)
-	
+
 	go func() {
-		if err := http.ListenAndServe(fmt.Sprintf(":%d", s.port), mux); err != nil {
+		if err := http.ListenAndServe(fmt.Sprintf(":%d", s.port), s.Handler()); err != nil {
 			fmt.Fprintf(os.Stderr, "[Server] HTTP server error: %v\n", err)
 		}
 	}()
@@ -59,18 +113,72 @@ func (s *Server) Start() error {
 	return nil
 }
 
+// requireAuth wraps handler so it only runs for requests bearing a valid
+// token issued to a paired peer. Unknown or unpaired peers get a 401.
+func (s *Server) requireAuth(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		fingerprint, ok := s.trust.VerifyBearer(token)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		fmt.Printf("[Server] Authenticated request from %s\n", fingerprint)
+		handler(w, r)
+	}
+}
+
+// handlePair runs the responder side of the Noise-IK pairing handshake.
+// The caller's first handshake message is the raw request body; the reply
+// message is the raw response body.
+func (s *Server) handlePair(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	msg1, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read handshake message", http.StatusBadRequest)
+		return
+	}
+
+	reply, peer, err := s.pairing.HandleInitMessage(msg1)
+	if err != nil {
+		http.Error(w, "Pairing handshake failed", http.StatusBadRequest)
+		return
+	}
+
+	fmt.Printf("[Server] Pairing request from %s, SAS: %s\n", peer.Fingerprint, peer.SAS)
+	fmt.Printf("[PAIR_REQUEST] {\"fingerprint\":%q,\"sas\":%q}\n", peer.Fingerprint, peer.SAS)
+
+	w.Write(reply)
+}
+
+// handleGrab reports whether this device is currently holding a file or
+// directory.
+func (s *Server) handleGrab(w http.ResponseWriter, r *http.Request) {
+	if s.grab == nil {
+		http.Error(w, "Grab state unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	isHolding, name, isDir, totalSize, fileCount := s.grab()
+	heldType := "file"
+	if isDir {
+		heldType = "dir"
+	}
+	fmt.Fprintf(w, `{"isHolding":%t,"heldFile":%q,"heldType":%q,"heldSize":%d,"heldCount":%d}`,
+		isHolding, name, heldType, totalSize, fileCount)
+}
+
 // handleFileServe serves files from the shared directory
 func (s *Server) handleFileServe(w http.ResponseWriter, r *http.Request) {
 	// Extract filename from URL: /file/filename.ext
 	filename := filepath.Base(r.URL.Path)
-	
+
 	if filename == "" || filename == "." {
 		http.Error(w, "Invalid filename", http.StatusBadRequest)
 		return
 	}
 
 	filePath := filepath.Join(s.sharedDir, filename)
-	
+
 	// Check if file exists
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
 		http.Error(w, "File not found", http.StatusNotFound)
@@ -78,42 +186,15 @@ func (s *Server) handleFileServe(w http.ResponseWriter, r *http.Request) {
 	}
 
 	fmt.Printf("[Server] Serving file: %s\n", filename)
-	
+
 	// Set content disposition for download
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
-	
-	http.ServeFile(w, r, filePath)
-}
-
-// DownloadFile downloads a file from a remote URL
-func (s *Server) DownloadFile(url string, destPath string) error {
-	fmt.Printf("[Server] Downloading from %s to %s\n", url, destPath)
-
-	resp, err := http.Get(url)
-	if err != nil {
-		return fmt.Errorf("failed to download: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("bad status: %s", resp.Status)
-	}
 
-	// Create destination file
-	out, err := os.Create(destPath)
-	if err != nil {
-		return fmt.Errorf("failed to create file: %v", err)
-	}
-	defer out.Close()
-
-	// Copy data
-	_, err = io.Copy(out, resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to write file: %v", err)
-	}
-
-	fmt.Printf("[Server] Downloaded successfully: %s\n", destPath)
-	return nil
+	// http.ServeFile serves through http.ServeContent under the hood, which
+	// already honors a Range request header against the underlying
+	// io.ReadSeeker - this is what lets DownloadManifest fetch individual
+	// blocks instead of the whole file.
+	http.ServeFile(w, r, filePath)
 }
 
 // createDemoFile creates a test file for demo purposes